@@ -1,5 +1,16 @@
 package innsecure
 
+import (
+	"strconv"
+	"strings"
+)
+
+// Booking statuses.
+const (
+	BookingConfirmed = "confirmed"
+	BookingCancelled = "cancelled"
+)
+
 // Booking represents a booking.
 type Booking struct {
 	Type    string `json:"type"`
@@ -9,6 +20,29 @@ type Booking struct {
 	Arrive  string `json:"arrive"`
 	Leave   string `json:"leave"`
 	Name    string `json:"name"`
+	// Status is either BookingConfirmed or BookingCancelled. It is set by
+	// the service, so must be empty when creating a booking.
+	Status string `json:"status,omitempty"`
+}
+
+// CSVColumns are the columns of a CSV file of bookings, in the order written
+// by CSVRecord.
+var CSVColumns = []string{"id", "version", "hotel_id", "arrive", "leave", "name", "status"}
+
+// CSVRecord returns the fields of the booking in the order of CSVColumns.
+// Guest supplied values are escaped so that a spreadsheet opening the file
+// does not interpret them as formulae.
+func (b Booking) CSVRecord() []string {
+	return []string{b.ID, strconv.Itoa(b.Version), strconv.Itoa(b.HotelID), b.Arrive, b.Leave, csvSafe(b.Name), b.Status}
+}
+
+// csvSafe stops v from being interpreted as a formula when a CSV file is
+// opened in a spreadsheet.
+func csvSafe(v string) string {
+	if v != "" && strings.IndexByte("=+-@\t\r", v[0]) >= 0 {
+		return "'" + v
+	}
+	return v
 }
 
 // Listing contains a paginated list of bookings.
@@ -19,3 +53,54 @@ type Listing struct {
 	// and total found.
 	Data []Booking `json:"data"`
 }
+
+// ErasureRequest asks for a guest's personal data to be erased.
+type ErasureRequest struct {
+	// Name identifies the guest. It is matched ignoring case and surrounding
+	// whitespace.
+	Name string `json:"name"`
+}
+
+// Erasure reports the outcome of an ErasureRequest.
+type Erasure struct {
+	// Erased is the number of bookings from which personal data was removed.
+	Erased int `json:"erased"`
+}
+
+// Import results.
+const (
+	ImportCreated  = "created"
+	ImportRejected = "rejected"
+)
+
+// ImportRow is a booking to be imported, read from a line of an import file.
+type ImportRow struct {
+	// Line is the line of the file the booking was read from. For CSV files
+	// it counts records, including the header, so differs only where quoted
+	// fields span lines.
+	Line int
+	// Booking is the booking to create.
+	Booking Booking
+	// Errors lists the reasons the row could not be read, if any, in which
+	// case the booking is not created.
+	Errors []FieldError
+}
+
+// ImportResult reports the outcome of importing a single row.
+type ImportResult struct {
+	Line int `json:"line"`
+	// Status is either ImportCreated or ImportRejected.
+	Status string `json:"status"`
+	// ID is the ID of the created booking.
+	ID string `json:"id,omitempty"`
+	// Errors lists the reasons a rejected booking was not created.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// ImportReport reports the outcome of a bulk import. It never contains guest
+// personal data.
+type ImportReport struct {
+	Created  int            `json:"created"`
+	Rejected int            `json:"rejected"`
+	Results  []ImportResult `json:"results"`
+}
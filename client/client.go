@@ -0,0 +1,606 @@
+// Package client provides an innsecure.Service backed by a remote instance of
+// the booking service's HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/pborman/uuid"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/trace"
+)
+
+// StatusError is returned when the server responds with an unexpected
+// status which does not correspond to a domain error.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error satisfies error.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response %d: %s", e.StatusCode, e.Message)
+}
+
+// ErrNotSupported is returned by methods of innsecure.Service which the
+// client does not support.
+var ErrNotSupported = errors.New("not supported by the HTTP client")
+
+// Client is an innsecure.Service which calls a remote instance over HTTP.
+//
+// The remote instance identifies the user from the bearer token, so the
+// *innsecure.User passed to each method is only used to choose the hotel in
+// the request path.
+type Client struct {
+	listBookings   endpoint.Endpoint
+	createBooking  endpoint.Endpoint
+	getBookingByID endpoint.Endpoint
+	eraseGuest     endpoint.Endpoint
+	cancelBooking  endpoint.Endpoint
+	importBookings endpoint.Endpoint
+	exportBookings endpoint.Endpoint
+
+	createFeedToken endpoint.Endpoint
+	listFeedTokens  endpoint.Endpoint
+	revokeFeedToken endpoint.Endpoint
+
+	createWebhook         endpoint.Endpoint
+	listWebhooks          endpoint.Endpoint
+	deleteWebhook         endpoint.Endpoint
+	listWebhookDeliveries endpoint.Endpoint
+
+	hotelID int
+}
+
+type options struct {
+	hotelID    int
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithHotel sets the hotel used in request paths when no user is given.
+func WithHotel(hotelID int) Option {
+	return func(o *options) { o.hotelID = hotelID }
+}
+
+// WithTimeout sets the time limit for each attempt at a request. The default
+// is 10 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeout = d }
+}
+
+// WithRetries sets how many times idempotent requests are retried after a
+// network error or a 5xx response, waiting backoff, then twice as long, and
+// so on, between attempts. The default is 2 retries with a 100ms backoff.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to make requests, overriding the
+// timeout set by WithTimeout.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.httpClient = c }
+}
+
+// New returns a Client for the instance at the given base URL, such as
+// "https://innsecure.example.com", authenticating with the given bearer token.
+func New(instance, token string, opts ...Option) (*Client, error) {
+	o := options{
+		timeout: 10 * time.Second,
+		retries: 2,
+		backoff: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.httpClient == nil {
+		o.httpClient = &http.Client{Timeout: o.timeout}
+	}
+
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	base, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	clientOptions := []httptransport.ClientOption{
+		httptransport.SetClient(o.httpClient),
+		httptransport.ClientBefore(setToken(token), injectTrace),
+	}
+	retry := retryMiddleware(o.retries, o.backoff)
+
+	return &Client{
+		listBookings: retry(httptransport.NewClient(
+			"GET", base,
+			encodePathRequest,
+			decodeListingResponse,
+			clientOptions...,
+		).Endpoint()),
+		createBooking: retry(httptransport.NewClient(
+			"POST", base,
+			encodeJSONRequest,
+			decodeBookingResponse,
+			clientOptions...,
+		).Endpoint()),
+		getBookingByID: retry(httptransport.NewClient(
+			"GET", base,
+			encodePathRequest,
+			decodeBookingResponse,
+			clientOptions...,
+		).Endpoint()),
+		eraseGuest: httptransport.NewClient(
+			"POST", base,
+			encodeJSONRequest,
+			decodeErasureResponse,
+			clientOptions...,
+		).Endpoint(),
+		cancelBooking: retry(httptransport.NewClient(
+			"POST", base,
+			encodePathRequest,
+			decodeBookingResponse,
+			clientOptions...,
+		).Endpoint()),
+		importBookings: httptransport.NewClient(
+			"POST", base,
+			encodeImportRequest,
+			decodeImportResponse,
+			clientOptions...,
+		).Endpoint(),
+		exportBookings: httptransport.NewClient(
+			"GET", base,
+			encodePathRequest,
+			decodeExportResponse,
+			append(clientOptions, httptransport.BufferedStream(true))...,
+		).Endpoint(),
+		createFeedToken: httptransport.NewClient(
+			"POST", base,
+			encodePathRequest,
+			decodeFeedTokenResponse,
+			clientOptions...,
+		).Endpoint(),
+		listFeedTokens: retry(httptransport.NewClient(
+			"GET", base,
+			encodePathRequest,
+			decodeFeedTokenListingResponse,
+			clientOptions...,
+		).Endpoint()),
+		revokeFeedToken: retry(httptransport.NewClient(
+			"DELETE", base,
+			encodePathRequest,
+			decodeFeedTokenResponse,
+			clientOptions...,
+		).Endpoint()),
+		createWebhook: httptransport.NewClient(
+			"POST", base,
+			encodeJSONRequest,
+			decodeWebhookResponse,
+			clientOptions...,
+		).Endpoint(),
+		listWebhooks: retry(httptransport.NewClient(
+			"GET", base,
+			encodePathRequest,
+			decodeWebhookListingResponse,
+			clientOptions...,
+		).Endpoint()),
+		deleteWebhook: httptransport.NewClient(
+			"DELETE", base,
+			encodePathRequest,
+			decodeWebhookResponse,
+			clientOptions...,
+		).Endpoint(),
+		listWebhookDeliveries: retry(httptransport.NewClient(
+			"GET", base,
+			encodePathRequest,
+			decodeWebhookDeliveryListingResponse,
+			clientOptions...,
+		).Endpoint()),
+		hotelID: o.hotelID,
+	}, nil
+}
+
+// request is the request for every client endpoint: a path relative to the
+// base URL, an optional JSON body and an optional idempotency key.
+type request struct {
+	path           string
+	body           interface{}
+	idempotencyKey string
+}
+
+// apiVersion is the version of the API the client speaks, which prefixes
+// every path.
+const apiVersion = "v1"
+
+func (c *Client) hotelPath(u *innsecure.User, format string, a ...interface{}) string {
+	hotelID := c.hotelID
+	if u != nil {
+		hotelID = u.HotelID
+	}
+	return fmt.Sprintf("/%s/hotels/%d", apiVersion, hotelID) + fmt.Sprintf(format, a...)
+}
+
+// ListBookings satisfies innsecure.Service.
+func (c *Client) ListBookings(ctx context.Context, u *innsecure.User) (*innsecure.Listing, error) {
+	resp, err := c.listBookings(ctx, request{path: c.hotelPath(u, "/bookings")})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Listing), nil
+}
+
+// CreateBooking satisfies innsecure.Service. Each booking is sent with a new
+// idempotency key, so it is retried like a read without the risk of creating
+// it twice.
+func (c *Client) CreateBooking(ctx context.Context, u *innsecure.User, b innsecure.Booking) (*innsecure.Booking, error) {
+	resp, err := c.createBooking(ctx, request{path: c.hotelPath(u, "/bookings"), body: b, idempotencyKey: uuid.New()})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Booking), nil
+}
+
+// GetBookingByID satisfies innsecure.Service.
+func (c *Client) GetBookingByID(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Booking, error) {
+	resp, err := c.getBookingByID(ctx, request{path: c.hotelPath(u, "/bookings/%s", url.PathEscape(ID))})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Booking), nil
+}
+
+// EraseGuest satisfies innsecure.Service.
+func (c *Client) EraseGuest(ctx context.Context, u *innsecure.User, r innsecure.ErasureRequest) (*innsecure.Erasure, error) {
+	resp, err := c.eraseGuest(ctx, request{path: c.hotelPath(u, "/erasures"), body: r})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Erasure), nil
+}
+
+// CancelBooking satisfies innsecure.Service. Cancellation is idempotent, so
+// it is retried like a read.
+func (c *Client) CancelBooking(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Booking, error) {
+	resp, err := c.cancelBooking(ctx, request{path: c.hotelPath(u, "/bookings/%s/cancel", url.PathEscape(ID))})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Booking), nil
+}
+
+// ImportBookings satisfies innsecure.Service. The bookings are sent as
+// NDJSON, so the line of each result is the position of its row, counting
+// from 1. The Line and Errors of each row are ignored.
+func (c *Client) ImportBookings(ctx context.Context, u *innsecure.User, rows []innsecure.ImportRow) (*innsecure.ImportReport, error) {
+	resp, err := c.importBookings(ctx, request{path: c.hotelPath(u, "/bookings:import"), body: rows})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.ImportReport), nil
+}
+
+// ExportBookings satisfies innsecure.Service. Bookings are decoded as they
+// are received, but the whole export must be received within the timeout set
+// by WithTimeout.
+func (c *Client) ExportBookings(ctx context.Context, u *innsecure.User, fn func(innsecure.Booking) error) error {
+	resp, err := c.exportBookings(ctx, request{path: c.hotelPath(u, "/bookings:export")})
+	if err != nil {
+		return err
+	}
+	body := resp.(io.ReadCloser)
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var b innsecure.Booking
+		if err := dec.Decode(&b); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+}
+
+// CreateFeedToken satisfies innsecure.Service.
+func (c *Client) CreateFeedToken(ctx context.Context, u *innsecure.User) (*innsecure.FeedToken, error) {
+	resp, err := c.createFeedToken(ctx, request{path: c.hotelPath(u, "/feed-tokens")})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.FeedToken), nil
+}
+
+// ListFeedTokens satisfies innsecure.Service.
+func (c *Client) ListFeedTokens(ctx context.Context, u *innsecure.User) (*innsecure.FeedTokenListing, error) {
+	resp, err := c.listFeedTokens(ctx, request{path: c.hotelPath(u, "/feed-tokens")})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.FeedTokenListing), nil
+}
+
+// RevokeFeedToken satisfies innsecure.Service. Revocation is idempotent, so
+// it is retried like a read.
+func (c *Client) RevokeFeedToken(ctx context.Context, u *innsecure.User, ID string) (*innsecure.FeedToken, error) {
+	resp, err := c.revokeFeedToken(ctx, request{path: c.hotelPath(u, "/feed-tokens/%s", url.PathEscape(ID))})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.FeedToken), nil
+}
+
+// CreateWebhook satisfies innsecure.Service.
+func (c *Client) CreateWebhook(ctx context.Context, u *innsecure.User, w innsecure.Webhook) (*innsecure.Webhook, error) {
+	body := struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}{w.URL, w.Events}
+	resp, err := c.createWebhook(ctx, request{path: c.hotelPath(u, "/webhooks"), body: body})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Webhook), nil
+}
+
+// ListWebhooks satisfies innsecure.Service.
+func (c *Client) ListWebhooks(ctx context.Context, u *innsecure.User) (*innsecure.WebhookListing, error) {
+	resp, err := c.listWebhooks(ctx, request{path: c.hotelPath(u, "/webhooks")})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.WebhookListing), nil
+}
+
+// DeleteWebhook satisfies innsecure.Service. It is not retried, as a retry
+// after the webhook was deleted would report it as not found.
+func (c *Client) DeleteWebhook(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Webhook, error) {
+	resp, err := c.deleteWebhook(ctx, request{path: c.hotelPath(u, "/webhooks/%s", url.PathEscape(ID))})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.Webhook), nil
+}
+
+// ListWebhookDeliveries satisfies innsecure.Service.
+func (c *Client) ListWebhookDeliveries(ctx context.Context, u *innsecure.User, webhookID string) (*innsecure.WebhookDeliveryListing, error) {
+	resp, err := c.listWebhookDeliveries(ctx, request{path: c.hotelPath(u, "/webhooks/%s/deliveries", url.PathEscape(webhookID))})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*innsecure.WebhookDeliveryListing), nil
+}
+
+// FeedBookings satisfies innsecure.Service, but is not supported: the feed is
+// an iCalendar file for calendar clients, and the same bookings are available
+// from ListBookings.
+func (c *Client) FeedBookings(ctx context.Context, hotelID int, token string) (*innsecure.Listing, error) {
+	return nil, ErrNotSupported
+}
+
+// WatchBookings satisfies innsecure.Service, but is not supported: the change
+// feed is a stream of server-sent events, best consumed by an EventSource
+// client which reconnects and resumes as the stream requires.
+func (c *Client) WatchBookings(ctx context.Context, u *innsecure.User, after int64, fn func(innsecure.BookingEvent) error) error {
+	return ErrNotSupported
+}
+
+func setToken(token string) httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return ctx
+	}
+}
+
+// injectTrace continues the trace of the span in the request's context, if
+// there is one, in the service.
+func injectTrace(ctx context.Context, r *http.Request) context.Context {
+	trace.Inject(ctx, r.Header)
+	return ctx
+}
+
+// encodePathRequest appends the request's path, whose segments are already
+// escaped, to the URL.
+func encodePathRequest(_ context.Context, r *http.Request, req interface{}) error {
+	path := req.(request).path
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return err
+	}
+	r.URL.RawPath = r.URL.EscapedPath() + path
+	r.URL.Path += unescaped
+	return nil
+}
+
+func encodeJSONRequest(ctx context.Context, r *http.Request, req interface{}) error {
+	if err := encodePathRequest(ctx, r, req); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req.(request).body); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if key := req.(request).idempotencyKey; key != "" {
+		r.Header.Set(innsecure.IdempotencyKeyHeader, key)
+	}
+	r.ContentLength = int64(buf.Len())
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func encodeImportRequest(ctx context.Context, r *http.Request, req interface{}) error {
+	if err := encodePathRequest(ctx, r, req); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, row := range req.(request).body.([]innsecure.ImportRow) {
+		if err := enc.Encode(row.Booking); err != nil {
+			return err
+		}
+	}
+	r.Header.Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	r.ContentLength = int64(buf.Len())
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func decodeListingResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var l innsecure.Listing
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func decodeBookingResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var b innsecure.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func decodeImportResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var report innsecure.ImportReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// decodeExportResponse returns the body of the response, which the caller
+// must close, for the bookings to be decoded as they are received.
+func decodeExportResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func decodeFeedTokenResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var t innsecure.FeedToken
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func decodeFeedTokenListingResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var l innsecure.FeedTokenListing
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func decodeWebhookResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var w innsecure.Webhook
+	if err := json.NewDecoder(resp.Body).Decode(&w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func decodeWebhookListingResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var l innsecure.WebhookListing
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func decodeWebhookDeliveryListingResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var l innsecure.WebhookDeliveryListing
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func decodeErasureResponse(_ context.Context, resp *http.Response) (interface{}, error) {
+	if err := errorFrom(resp); err != nil {
+		return nil, err
+	}
+	var e innsecure.Erasure
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// errorFrom converts an unsuccessful response into an *innsecure.Error
+// wrapping the domain error the server reported, so that callers can use
+// errors.Is(err, innsecure.ErrNotFound) and the like, and inspect any field
+// errors. Responses which are not recognised are returned as a *StatusError.
+func errorFrom(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var p struct {
+		Code   string                 `json:"code"`
+		Detail string                 `json:"detail"`
+		Fields []innsecure.FieldError `json:"fields"`
+	}
+	json.NewDecoder(resp.Body).Decode(&p)
+	if err := innsecure.ErrorForCode(p.Code); err != nil {
+		return &innsecure.Error{
+			Code:    p.Code,
+			Message: p.Detail,
+			Status:  resp.StatusCode,
+			Fields:  p.Fields,
+			Err:     err,
+		}
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Message: p.Detail}
+}
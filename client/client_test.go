@@ -0,0 +1,134 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/client"
+)
+
+// svc is a basic Service mock.
+type svc struct {
+	innsecure.Service
+	getBookingByID func(ctx context.Context, ID string) (*innsecure.Booking, error)
+}
+
+func (s svc) GetBookingByID(ctx context.Context, _ *innsecure.User, ID string) (*innsecure.Booking, error) {
+	return s.getBookingByID(ctx, ID)
+}
+
+func TestClientImplementsService(t *testing.T) {
+	var sut interface{} = &client.Client{}
+	if _, ok := sut.(innsecure.Service); !ok {
+		t.Fatal("Client does not satisfy Service.")
+	}
+}
+
+func newServer(t *testing.T, s innsecure.Service) *httptest.Server {
+	requireToken := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if ctx.Value(jwt.JWTContextKey) != "token" {
+				return nil, innsecure.ErrUnauthorized
+			}
+			return next(ctx, request)
+		}
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(s, requireToken), log.NewNopLogger())
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCanGetBookingByID(t *testing.T) {
+	srv := newServer(t, svc{
+		getBookingByID: func(_ context.Context, ID string) (*innsecure.Booking, error) {
+			return &innsecure.Booking{ID: ID, HotelID: 123}, nil
+		},
+	})
+	sut, err := client.New(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := sut.GetBookingByID(context.TODO(), &innsecure.User{HotelID: 123}, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "A" || got.HotelID != 123 {
+		t.Fatalf("unexpected booking: %+v", got)
+	}
+}
+
+func TestEscapesIDsInPaths(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.EscapedPath()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "A"}`))
+	}))
+	t.Cleanup(srv.Close)
+	sut, _ := client.New(srv.URL, "token")
+
+	if _, err := sut.GetBookingByID(context.TODO(), &innsecure.User{HotelID: 123}, "../erasures?x"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/hotels/123/bookings/..%2Ferasures%3Fx"; got != want {
+		t.Fatalf("want=%s, got=%s", want, got)
+	}
+}
+
+func TestConvertsErrorResponsesToDomainErrors(t *testing.T) {
+	cases := map[string]struct {
+		token   string
+		err     error
+		wantErr error
+	}{
+		"Not found":    {token: "token", err: innsecure.ErrNotFound, wantErr: innsecure.ErrNotFound},
+		"Unauthorised": {token: "wrong", wantErr: innsecure.ErrUnauthorized},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			srv := newServer(t, svc{
+				getBookingByID: func(_ context.Context, _ string) (*innsecure.Booking, error) {
+					return nil, c.err
+				},
+			})
+			sut, _ := client.New(srv.URL, c.token)
+
+			_, err := sut.GetBookingByID(context.TODO(), nil, "A")
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("want=%s, got=%v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	var calls int32
+	srv := newServer(t, svc{
+		getBookingByID: func(_ context.Context, ID string) (*innsecure.Booking, error) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return nil, innsecure.ErrDatabase
+			}
+			return &innsecure.Booking{ID: ID}, nil
+		},
+	})
+	sut, _ := client.New(srv.URL, "token", client.WithRetries(2, time.Millisecond))
+
+	if _, err := sut.GetBookingByID(context.TODO(), nil, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("want=3 calls, got=%d", calls)
+	}
+}
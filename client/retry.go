@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+
+	"github.com/form3tech/innsecure"
+)
+
+// retryMiddleware retries an idempotent endpoint up to retries times when it
+// fails with an error that may be transient, doubling the wait between
+// attempts each time.
+func retryMiddleware(retries int, backoff time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			wait := backoff
+			for attempt := 0; ; attempt++ {
+				resp, err := next(ctx, request)
+				if err == nil || attempt >= retries || !retryable(err) {
+					return resp, err
+				}
+				select {
+				case <-ctx.Done():
+					return nil, err
+				case <-time.After(wait):
+				}
+				wait *= 2
+			}
+		}
+	}
+}
+
+// retryable reports whether a request which failed with err may succeed if
+// repeated. Client errors will not change, but network errors and server
+// errors may.
+func retryable(err error) bool {
+	var e *innsecure.Error
+	if errors.As(err, &e) {
+		return e.Status >= 500 || e.Status == 429
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 || se.StatusCode == 429
+	}
+	return true
+}
@@ -1,26 +1,35 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/config"
+	"github.com/form3tech/innsecure/health"
 	"github.com/form3tech/innsecure/jwtauth"
+	"github.com/form3tech/innsecure/keyring"
+	"github.com/form3tech/innsecure/metrics"
+	"github.com/form3tech/innsecure/pb"
 	"github.com/form3tech/innsecure/postgres"
+	"github.com/form3tech/innsecure/trace"
 	"github.com/go-kit/kit/log"
 	_ "github.com/lib/pq"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	var (
-		httpAddr = flag.String("http.addr", ":8080", "HTTP listen address")
-	)
-	flag.Parse()
-
 	var logger log.Logger
 	{
 		logger = log.NewJSONLogger(os.Stdout)
@@ -28,42 +37,328 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
-	var s innsecure.Service
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			logger.Log("msg", "invalid configuration", "err", err)
+		}
+		os.Exit(2)
+	}
+	logger.Log(append([]interface{}{"msg", "configuration"}, cfg.Redacted()...)...)
+
+	// ctx is cancelled to stop background jobs, which jobs waits for.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var jobs sync.WaitGroup
+	run := func(job func(context.Context)) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			job(ctx)
+		}()
+	}
+
+	tracer, closeTraces, err := newTracer(cfg.Trace.Exporter, cfg.Trace.File, log.With(logger, "component", "trace"))
+	if err != nil {
+		panic(err)
+	}
+
+	checks := health.NewRegistry(2 * time.Second)
+	registry := metrics.NewRegistry()
+	m := innsecure.NewMetrics(registry)
+
+	var (
+		s           innsecure.Service
+		idempotency innsecure.IdempotencyStore
+		dbs         = map[string]*sql.DB{}
+	)
+	{
+		keys, err := keyring.Load(cfg.KeyringFile)
+		if err != nil {
+			panic(err)
+		}
+
+		db, err := postgres.NewConnection(ctx, cfg.DB, log.With(logger, "component", "postgres"))
+		if err != nil {
+			panic(err)
+		}
+		dbs["primary"] = db
+
+		checks.AddReadinessCheck("postgres", health.CheckerFunc(db.PingContext))
+		checks.AddReadinessCheck("schema", health.CheckerFunc(func(ctx context.Context) error {
+			return postgres.CheckSchema(ctx, db)
+		}))
+		checks.AddReadinessCheck("keyring", health.CheckerFunc(func(context.Context) error {
+			return keys.Check()
+		}))
+
+		r := postgres.NewRepo(db, keys)
+
+		if cfg.Replica.Host != "" {
+			replicaCfg, err := cfg.ReplicaDB()
+			if err != nil {
+				panic(err)
+			}
+			replicaLogger := log.With(logger, "component", "postgres-replica")
+			replicaDB, err := postgres.NewConnection(ctx, replicaCfg, replicaLogger)
+			if err != nil {
+				panic(err)
+			}
+			dbs["replica"] = replicaDB
+
+			replica := postgres.NewReplica(replicaDB, cfg.Replica.MaxLag, replicaLogger)
+			run(func(ctx context.Context) { replica.Monitor(ctx, 5*time.Second) })
+			r.UseReplica(replica)
+		}
+
+		svc := innsecure.NewBookingService(innsecure.TraceRepository(r))
+		svc.AllowPrivateWebhooks(cfg.Webhooks.AllowPrivate)
+		svc.SetMetrics(m)
+		postgres.InstrumentDBs(registry, dbs)
+		s = innsecure.TraceService(svc)
+		idempotency = postgres.NewIdempotencyStore(db, keys)
+
+		job := innsecure.NewRetentionJob(r, cfg.Retention.Interval, cfg.Retention.Days, cfg.Retention.Events, log.With(logger, "component", "retention"))
+		run(job.Run)
+
+		dispatcher := innsecure.NewWebhookDispatcher(r, cfg.Webhooks.Interval, cfg.Webhooks.AllowPrivate, log.With(logger, "component", "webhooks"))
+		dispatcher.SetTracer(tracer)
+		run(dispatcher.Run)
+	}
+
+	var (
+		h http.Handler
+		g *grpc.Server
+	)
 	{
-		host := os.Getenv("DB_HOST")
-		user := os.Getenv("DB_USER")
-		pass := os.Getenv("DB_PASSWORD")
-		db, err := postgres.NewConnection(host, user, pass)
+		e := innsecure.MakeServerEndpoints(s, jwtauth.NewMiddleware(cfg.JWTSigningString), innsecure.WithIdempotency(idempotency, cfg.IdempotencyTTL))
+		e = m.InstrumentEndpoints(e)
+		e = e.With(innsecure.TraceEndpoint)
+		deprecations, err := loadDeprecations(cfg.HTTP.Deprecations)
 		if err != nil {
 			panic(err)
 		}
-		defer db.Close()
+		h = innsecure.MakeHTTPHandler(e, log.With(logger, "component", "HTTP"), innsecure.WithDeprecations(deprecations))
+		h = withDBSession(h)
+		h = innsecure.CORS(cfg.HTTP.CORSOrigins)(h)
+		h = innsecure.SecurityHeaders(cfg.HTTP.HSTS)(h)
+		h = innsecure.AccessLog(log.With(logger, "component", "access"))(h)
+		h = innsecure.Tracing(tracer)(h)
+
+		g = grpc.NewServer(grpc.ChainUnaryInterceptor(
+			innsecure.GRPCTracing(tracer),
+			innsecure.GRPCRecovery(log.With(logger, "component", "gRPC")),
+			grpcDBSession,
+		))
+		pb.RegisterBookingsServer(g, innsecure.MakeGRPCServer(e, log.With(logger, "component", "gRPC")))
+	}
 
-		r := postgres.NewRepo(db)
-		s = innsecure.NewBookingService(r)
+	// shutdown is closed once the HTTP server starts shutting down, to end
+	// event streams.
+	shutdown := make(chan struct{})
+	srv := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		ConnContext:       innsecure.ConnContext,
+		BaseContext: func(net.Listener) context.Context {
+			return innsecure.ContextWithShutdown(context.Background(), shutdown)
+		},
 	}
+	srv.RegisterOnShutdown(func() { close(shutdown) })
 
-	var h http.Handler
+	admin := &http.Server{
+		Addr:              cfg.Admin.Addr,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
 	{
-		jwtmw := jwtauth.NewMiddleware("SigningString")
-		e := innsecure.MakeServerEndpoints(s, jwtmw)
-		h = innsecure.MakeHTTPHandler(e, log.With(logger, "component", "HTTP"))
+		mux := http.NewServeMux()
+		checks.Handle(mux)
+		mux.Handle("/metrics", registry.Handler())
+		admin.Handler = mux
 	}
 
-	errs := make(chan error)
+	// errs receives the error of a transport which stops other than by
+	// being shut down.
+	errs := make(chan error, 3)
 
-	// Shutdown handler
+	// HTTP Transport
 	go func() {
-		c := make(chan os.Signal)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errs <- fmt.Errorf("%s", <-c)
+		logger.Log("transport", "HTTP", "addr", cfg.HTTP.Addr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			errs <- err
+		}
 	}()
 
-	// HTTP Transport
+	// Admin transport
 	go func() {
-		logger.Log("transport", "HTTP", "addr", *httpAddr)
-		errs <- http.ListenAndServe(*httpAddr, h)
+		logger.Log("transport", "admin", "addr", cfg.Admin.Addr)
+		if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+			errs <- err
+		}
 	}()
 
-	logger.Log("exit", <-errs)
+	// gRPC transport
+	go func() {
+		lis, err := net.Listen("tcp", cfg.GRPC.Addr)
+		if err != nil {
+			errs <- err
+			return
+		}
+		logger.Log("transport", "gRPC", "addr", cfg.GRPC.Addr)
+		if err := g.Serve(lis); err != nil {
+			errs <- err
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case sig := <-sigs:
+		// A second signal kills the process at once, rather than waiting
+		// out the shutdown delay and drain.
+		signal.Stop(sigs)
+		// Load balancers are given time to notice that the instance is
+		// not ready, and stop sending it requests, before it stops
+		// accepting them.
+		checks.Shutdown()
+		logger.Log("msg", "shutting down", "signal", sig, "delay", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	case err := <-errs:
+		signal.Stop(sigs)
+		checks.Shutdown()
+		logger.Log("msg", "shutting down", "err", err)
+		exitCode = 1
+	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDrain()
+	if err := drain(drainCtx, srv, g, cancel, &jobs); err != nil {
+		logger.Log("msg", "shutdown timed out", "err", err)
+		exitCode = 1
+	}
+	// The admin listener is shut down last, so that readiness is reported
+	// as failing until the instance has drained.
+	if err := admin.Shutdown(drainCtx); err != nil {
+		admin.Close()
+	}
+	for name, db := range dbs {
+		if err := db.Close(); err != nil {
+			logger.Log("msg", "failed to close database", "db", name, "err", err)
+		}
+	}
+	if err := closeTraces(); err != nil {
+		logger.Log("msg", "failed to close traces", "err", err)
+	}
+	logger.Log("msg", "shut down")
+	os.Exit(exitCode)
+}
+
+// drain stops the HTTP and gRPC servers accepting requests, and waits for
+// those in flight to finish. It then stops background jobs, by calling
+// stopJobs, and waits for them too. If ctx is done first, requests still in
+// flight are aborted, and ctx's error is returned.
+func drain(ctx context.Context, srv *http.Server, g *grpc.Server, stopJobs func(), jobs *sync.WaitGroup) error {
+	var servers sync.WaitGroup
+	servers.Add(2)
+	go func() {
+		defer servers.Done()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}()
+	go func() {
+		defer servers.Done()
+		stopped := make(chan struct{})
+		go func() {
+			g.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			g.Stop()
+		}
+	}()
+	servers.Wait()
+
+	// Jobs are stopped only once requests have finished, as the replica
+	// monitor decides where their reads go.
+	stopJobs()
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return ctx.Err()
+}
+
+// loadDeprecations reads the deprecated routes from a JSON object such as
+// {"GET /hotels/{org_id}/bookings": {"deprecated_at": "2021-09-01T00:00:00Z"}}.
+// An empty path means that no routes are deprecated.
+func loadDeprecations(path string) (map[string]innsecure.Deprecation, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var deprecations map[string]innsecure.Deprecation
+	if err := json.NewDecoder(f).Decode(&deprecations); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for route, d := range deprecations {
+		if d.At.IsZero() {
+			return nil, fmt.Errorf("%s: %s: deprecated_at is required", path, route)
+		}
+	}
+	return deprecations, nil
+}
+
+// newTracer returns a Tracer exporting spans as the named exporter does, and
+// a function to call once no more spans will end.
+func newTracer(exporter, path string, logger log.Logger) (*trace.Tracer, func() error, error) {
+	noop := func() error { return nil }
+	switch exporter {
+	case "none":
+		// Trace context is still propagated, and trace IDs logged.
+		return trace.NewTracer(nil), noop, nil
+	case "log":
+		return trace.NewTracer(trace.NewLogExporter(logger)), noop, nil
+	case "json":
+		if path == "" {
+			return trace.NewTracer(trace.NewJSONExporter(os.Stdout)), noop, nil
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		return trace.NewTracer(trace.NewJSONExporter(f)), f.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown trace exporter %q", exporter)
+}
+
+// withDBSession gives each request its own database session, so that reads
+// made after a write in the same request observe it.
+func withDBSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(postgres.WithSession(r.Context())))
+	})
+}
+
+// grpcDBSession is the gRPC equivalent of withDBSession.
+func grpcDBSession(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(postgres.WithSession(ctx), req)
 }
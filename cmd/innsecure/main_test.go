@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestDrainStopsJobsAfterRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var (
+		mu       sync.Mutex
+		finished bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	go http.Get(srv.URL)
+	<-started
+
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	stop := make(chan struct{})
+	var finishedFirst bool
+	go func() {
+		defer jobs.Done()
+		<-stop
+		mu.Lock()
+		finishedFirst = finished
+		mu.Unlock()
+	}()
+
+	drained := make(chan error)
+	go func() {
+		drained <- drain(context.Background(), srv.Config, grpc.NewServer(), func() { close(stop) }, &jobs)
+	}()
+	select {
+	case err := <-drained:
+		t.Fatalf("want drain to wait for the request, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if !finishedFirst {
+		t.Fatal("want jobs stopped only once the request had finished")
+	}
+}
+
+func TestDrainGivesUpAtDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The job never finishes.
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	stopped := false
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = drain(ctx, srv.Config, grpc.NewServer(), func() { stopped = true }, &jobs)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want the deadline exceeded, got %v", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("want drain to give up at the deadline, took %s", took)
+	}
+	if !stopped {
+		t.Fatal("want jobs stopped")
+	}
+	if _, err := resp.Body.Read(make([]byte, 1)); err == nil {
+		t.Fatal("want the hung request aborted")
+	}
+}
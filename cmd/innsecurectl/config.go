@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// settings are where to find the server and how to authenticate with it.
+// Each is taken from the first of the command line flags, the environment
+// and the config file to set it.
+type settings struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+	Hotel  int    `json:"hotel"`
+}
+
+// defaultConfigFile returns the path of the config file used when none is
+// given on the command line.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "innsecurectl.json")
+}
+
+// resolve fills in any settings not given as flags from the environment,
+// read with getenv, and then from the config file at path. The config file
+// holds a token, so it is rejected if other users can read it.
+func resolve(flags settings, path string, pathGiven bool, getenv func(string) string) (settings, error) {
+	s := flags
+	if s.Server == "" {
+		s.Server = getenv("INNSECURE_SERVER")
+	}
+	if s.Token == "" {
+		s.Token = getenv("INNSECURE_TOKEN")
+	}
+	if s.Hotel == 0 {
+		if v := getenv("INNSECURE_HOTEL"); v != "" {
+			h, err := strconv.Atoi(v)
+			if err != nil {
+				return s, fmt.Errorf("INNSECURE_HOTEL must be an integer: %w", err)
+			}
+			s.Hotel = h
+		}
+	}
+
+	if path != "" {
+		file, err := readConfigFile(path)
+		switch {
+		case os.IsNotExist(err) && !pathGiven:
+		case err != nil:
+			return s, err
+		default:
+			if s.Server == "" {
+				s.Server = file.Server
+			}
+			if s.Token == "" {
+				s.Token = file.Token
+			}
+			if s.Hotel == 0 {
+				s.Hotel = file.Hotel
+			}
+		}
+	}
+
+	switch {
+	case s.Server == "":
+		return s, fmt.Errorf("no server given; use -server, INNSECURE_SERVER or the config file")
+	case s.Token == "":
+		return s, fmt.Errorf("no token given; use -token, INNSECURE_TOKEN or the config file")
+	case s.Hotel == 0:
+		return s, fmt.Errorf("no hotel given; use -hotel, INNSECURE_HOTEL or the config file")
+	}
+	return s, nil
+}
+
+func readConfigFile(path string) (settings, error) {
+	var s settings
+	fi, err := os.Stat(path)
+	if err != nil {
+		return s, err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return s, fmt.Errorf("config file %s must not be accessible by other users (try chmod 600)", path)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return s, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return s, nil
+}
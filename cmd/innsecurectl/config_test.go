@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// env returns a getenv function reading from vars.
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+// writeConfig writes a config file with the given permissions and returns
+// its path.
+func writeConfig(t *testing.T, content string, perm os.FileMode) string {
+	path := filepath.Join(t.TempDir(), "innsecurectl.json")
+	if err := ioutil.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile's permissions are subject to the umask.
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	file := writeConfig(t, `{"server": "https://file.example.com", "token": "file-token", "hotel": 1}`, 0600)
+	cases := map[string]struct {
+		flags settings
+		env   map[string]string
+		want  settings
+	}{
+		"file": {
+			want: settings{Server: "https://file.example.com", Token: "file-token", Hotel: 1},
+		},
+		"env over file": {
+			env:  map[string]string{"INNSECURE_TOKEN": "env-token", "INNSECURE_HOTEL": "2"},
+			want: settings{Server: "https://file.example.com", Token: "env-token", Hotel: 2},
+		},
+		"flags over env": {
+			flags: settings{Server: "https://flag.example.com", Hotel: 3},
+			env:   map[string]string{"INNSECURE_SERVER": "https://env.example.com", "INNSECURE_TOKEN": "env-token", "INNSECURE_HOTEL": "2"},
+			want:  settings{Server: "https://flag.example.com", Token: "env-token", Hotel: 3},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			got, err := resolve(c.flags, file, true, env(c.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Fatalf("want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveConfigFile(t *testing.T) {
+	vars := map[string]string{"INNSECURE_SERVER": "https://env.example.com", "INNSECURE_TOKEN": "env-token", "INNSECURE_HOTEL": "2"}
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	if _, err := resolve(settings{}, missing, false, env(vars)); err != nil {
+		t.Fatalf("want a missing default config file ignored, got %v", err)
+	}
+	if _, err := resolve(settings{}, missing, true, env(vars)); !os.IsNotExist(err) {
+		t.Fatalf("want a missing config file given on the command line rejected, got %v", err)
+	}
+
+	readable := writeConfig(t, `{"token": "file-token"}`, 0644)
+	if _, err := resolve(settings{}, readable, false, env(vars)); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Fatalf("want a world readable config file rejected, got %v", err)
+	}
+
+	if _, err := resolve(settings{}, "", false, env(map[string]string{"INNSECURE_SERVER": "https://env.example.com", "INNSECURE_HOTEL": "2"})); err == nil || !strings.Contains(err.Error(), "no token") {
+		t.Fatalf("want a missing token reported, got %v", err)
+	}
+	if _, err := resolve(settings{}, "", false, env(map[string]string{"INNSECURE_HOTEL": "two"})); err == nil || !strings.Contains(err.Error(), "INNSECURE_HOTEL") {
+		t.Fatalf("want a bad hotel reported, got %v", err)
+	}
+}
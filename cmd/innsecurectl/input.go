@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/form3tech/innsecure"
+)
+
+// readBookings reads bookings to create from a JSON or CSV file, chosen by
+// its extension. A JSON file holds a single booking or an array of them. A
+// CSV file has a header row naming its arrive, leave and name columns.
+func readBookings(path string) ([]innsecure.Booking, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return readJSONBookings(f)
+	case ".csv":
+		return readCSVBookings(f)
+	}
+	return nil, fmt.Errorf("%s: expected a .json or .csv file", path)
+}
+
+func readJSONBookings(r io.Reader) ([]innsecure.Booking, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	var bookings []innsecure.Booking
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+		err := json.Unmarshal(raw, &bookings)
+		return bookings, err
+	}
+	var b innsecure.Booking
+	err := json.Unmarshal(raw, &b)
+	return []innsecure.Booking{b}, err
+}
+
+func readCSVBookings(r io.Reader) ([]innsecure.Booking, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	cols := map[string]int{}
+	for i, name := range records[0] {
+		cols[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, name := range []string{"arrive", "leave", "name"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("CSV header has no %q column", name)
+		}
+	}
+	bookings := make([]innsecure.Booking, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		bookings = append(bookings, innsecure.Booking{
+			Arrive: rec[cols["arrive"]],
+			Leave:  rec[cols["leave"]],
+			Name:   rec[cols["name"]],
+		})
+	}
+	return bookings, nil
+}
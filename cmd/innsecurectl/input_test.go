@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+)
+
+func TestReadCSVBookings(t *testing.T) {
+	got, err := readCSVBookings(strings.NewReader("Name, Arrive,leave,notes\nJane Guest,2021-08-13,2021-08-15,late\n\"Guest, John\",2021-09-01,2021-09-02,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []innsecure.Booking{
+		{Arrive: "2021-08-13", Leave: "2021-08-15", Name: "Jane Guest"},
+		{Arrive: "2021-09-01", Leave: "2021-09-02", Name: "Guest, John"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want=%+v, got=%+v", want, got)
+	}
+
+	if _, err := readCSVBookings(strings.NewReader("arrive,name\n2021-08-13,Jane Guest\n")); err == nil || !strings.Contains(err.Error(), `"leave"`) {
+		t.Fatalf("want a missing column reported, got %v", err)
+	}
+	if got, err := readCSVBookings(strings.NewReader("")); err != nil || len(got) != 0 {
+		t.Fatalf("want no bookings from an empty file, got %+v (%v)", got, err)
+	}
+}
+
+func TestReadJSONBookings(t *testing.T) {
+	cases := map[string]struct {
+		in   string
+		want []innsecure.Booking
+	}{
+		"single": {
+			in:   `{"arrive": "2021-08-13", "leave": "2021-08-15", "name": "Jane Guest"}`,
+			want: []innsecure.Booking{{Arrive: "2021-08-13", Leave: "2021-08-15", Name: "Jane Guest"}},
+		},
+		"array": {
+			in:   ` [{"name": "Jane Guest"}, {"name": "John Guest", "hotel_id": 2}]`,
+			want: []innsecure.Booking{{Name: "Jane Guest"}, {Name: "John Guest", HotelID: 2}},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			got, err := readJSONBookings(strings.NewReader(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+
+	if _, err := readJSONBookings(strings.NewReader(`[{"name": `)); err == nil {
+		t.Fatal("want malformed JSON rejected")
+	}
+}
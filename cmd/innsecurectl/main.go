@@ -0,0 +1,212 @@
+// Command innsecurectl lets operators manage a hotel's bookings from the
+// command line.
+//
+//	innsecurectl [flags] list
+//	innsecurectl [flags] get <id>
+//	innsecurectl [flags] create -f bookings.csv
+//	innsecurectl [flags] create -arrive 2021-08-13 -leave 2021-08-15 -name "Jane Guest"
+//	innsecurectl [flags] cancel <id>...
+//	innsecurectl [flags] export [-format csv|json] [-out file]
+//
+// The server, token and hotel are read from flags, then the
+// INNSECURE_SERVER, INNSECURE_TOKEN and INNSECURE_HOTEL environment
+// variables, then a JSON config file with "server", "token" and "hotel"
+// fields. Prefer the environment or config file for the token: command line
+// flags are visible to other users of the machine.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/client"
+)
+
+func main() {
+	var (
+		flags      settings
+		configFile = flag.String("config", defaultConfigFile(), "Path to the config file")
+		format     = flag.String("o", formatTable, "Output format: table, json or csv")
+		timeout    = flag.Duration("timeout", 30*time.Second, "Time limit for each request")
+	)
+	flag.StringVar(&flags.Server, "server", "", "Server URL, e.g. https://innsecure.example.com")
+	flag.StringVar(&flags.Token, "token", "", "Bearer token")
+	flag.IntVar(&flags.Hotel, "hotel", 0, "Hotel ID")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	configGiven := false
+	flag.Visit(func(f *flag.Flag) { configGiven = configGiven || f.Name == "config" })
+	s, err := resolve(flags, *configFile, configGiven, os.Getenv)
+	if err != nil {
+		fatal(err)
+	}
+
+	c, err := client.New(s.Server, s.Token, client.WithHotel(s.Hotel), client.WithTimeout(*timeout))
+	if err != nil {
+		fatal(err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	ctx := context.Background()
+	switch cmd {
+	case "list":
+		err = list(ctx, c, *format)
+	case "get":
+		err = get(ctx, c, *format, args)
+	case "create":
+		err = create(ctx, c, s.Hotel, *format, args)
+	case "cancel":
+		err = cancel(ctx, c, *format, args)
+	case "export":
+		err = export(ctx, c, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: innsecurectl [flags] <command> [args]
+
+Commands:
+  list                   List the hotel's bookings
+  get <id>               Show a booking
+  create -f <file>       Create bookings from a .json or .csv file
+  create -arrive <date> -leave <date> -name <guest>
+                         Create a booking
+  cancel <id>...         Cancel bookings
+  export [-format csv|json] [-out <file>]
+                         Export all of the hotel's bookings
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "innsecurectl:", err)
+	os.Exit(1)
+}
+
+func list(ctx context.Context, s innsecure.Service, format string) error {
+	l, err := s.ListBookings(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return writeBookings(os.Stdout, format, l.Data)
+}
+
+func get(ctx context.Context, s innsecure.Service, format string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("get takes exactly one booking ID")
+	}
+	b, err := s.GetBookingByID(ctx, nil, args[0])
+	if err != nil {
+		return err
+	}
+	return writeBookings(os.Stdout, format, []innsecure.Booking{*b})
+}
+
+func create(ctx context.Context, s innsecure.Service, hotelID int, format string, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	var (
+		file   = fs.String("f", "", "Create bookings from a .json or .csv file")
+		arrive = fs.String("arrive", "", "Arrival date")
+		leave  = fs.String("leave", "", "Departure date")
+		name   = fs.String("name", "", "Guest name")
+	)
+	fs.Parse(args)
+
+	var bookings []innsecure.Booking
+	if *file != "" {
+		var err error
+		if bookings, err = readBookings(*file); err != nil {
+			return err
+		}
+	} else {
+		bookings = []innsecure.Booking{{Arrive: *arrive, Leave: *leave, Name: *name}}
+	}
+
+	// Bookings are created one at a time and reported as they are, so that
+	// a failure part way through shows which have already been created.
+	created := make([]innsecure.Booking, 0, len(bookings))
+	for i, b := range bookings {
+		b.Type = "Booking"
+		if b.HotelID == 0 {
+			b.HotelID = hotelID
+		}
+		got, err := s.CreateBooking(ctx, nil, b)
+		if err != nil {
+			writeBookings(os.Stdout, format, created)
+			return fmt.Errorf("booking %d of %d: %w", i+1, len(bookings), err)
+		}
+		created = append(created, *got)
+	}
+	return writeBookings(os.Stdout, format, created)
+}
+
+func cancel(ctx context.Context, s innsecure.Service, format string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("cancel takes one or more booking IDs")
+	}
+	cancelled := make([]innsecure.Booking, 0, len(args))
+	for _, id := range args {
+		b, err := s.CancelBooking(ctx, nil, id)
+		if err != nil {
+			writeBookings(os.Stdout, format, cancelled)
+			return fmt.Errorf("booking %s: %w", id, err)
+		}
+		cancelled = append(cancelled, *b)
+	}
+	return writeBookings(os.Stdout, format, cancelled)
+}
+
+func export(ctx context.Context, s innsecure.Service, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var (
+		format = fs.String("format", formatCSV, "Export format: csv or json")
+		out    = fs.String("out", "", "File to write to, instead of standard output")
+	)
+	fs.Parse(args)
+	if *format != formatCSV && *format != formatJSON {
+		return fmt.Errorf("unknown export format %q", *format)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		// The export contains guest personal data, so is only readable by
+		// its owner.
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// Bookings are written as they are received, so that exporting a large
+	// hotel does not hold every booking in memory. An export which fails part
+	// way through is incomplete.
+	write, finish, err := streamBookings(w, *format)
+	if err != nil {
+		return err
+	}
+	if err := s.ExportBookings(ctx, nil, write); err != nil {
+		return fmt.Errorf("export incomplete: %w", err)
+	}
+	return finish()
+}
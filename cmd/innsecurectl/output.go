@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/form3tech/innsecure"
+)
+
+// Output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// writeBookings writes bookings to w in the given format.
+func writeBookings(w io.Writer, format string, bookings []innsecure.Booking) error {
+	if format == formatTable {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tVERSION\tHOTEL\tARRIVE\tLEAVE\tNAME\tSTATUS")
+		for _, b := range bookings {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", b.ID, b.Version, b.HotelID, b.Arrive, b.Leave, b.Name, b.Status)
+		}
+		return tw.Flush()
+	}
+
+	write, finish, err := streamBookings(w, format)
+	if err != nil {
+		return err
+	}
+	for _, b := range bookings {
+		if err := write(b); err != nil {
+			return err
+		}
+	}
+	return finish()
+}
+
+// streamBookings returns functions writing bookings to w in the given JSON
+// or CSV format one at a time, and finishing the output after the last.
+func streamBookings(w io.Writer, format string) (write func(innsecure.Booking) error, finish func() error, err error) {
+	switch format {
+	case formatJSON:
+		// The bookings are written as an indented JSON array.
+		sep := "[\n  "
+		write = func(b innsecure.Booking) error {
+			raw, err := json.MarshalIndent(b, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s%s", sep, raw)
+			sep = ",\n  "
+			return err
+		}
+		finish = func() error {
+			end := "\n]\n"
+			if sep == "[\n  " {
+				end = "[]\n"
+			}
+			_, err := io.WriteString(w, end)
+			return err
+		}
+		return write, finish, nil
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(innsecure.CSVColumns); err != nil {
+			return nil, nil, err
+		}
+		write = func(b innsecure.Booking) error {
+			return cw.Write(b.CSVRecord())
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+		return write, finish, nil
+	}
+	return nil, nil, fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+)
+
+func TestWriteBookings(t *testing.T) {
+	bookings := []innsecure.Booking{
+		{ID: "a", HotelID: 123, Name: "Jane Guest", Status: innsecure.BookingConfirmed},
+		{ID: "b", HotelID: 123, Name: "=HYPERLINK()", Status: innsecure.BookingCancelled},
+	}
+	for _, bookings := range [][]innsecure.Booking{bookings, bookings[:1], {}} {
+		var buf bytes.Buffer
+		if err := writeBookings(&buf, formatJSON, bookings); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.MarshalIndent(bookings, "", "  ")
+		if got := buf.String(); got != string(want)+"\n" {
+			t.Fatalf("want=%s, got=%s", want, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeBookings(&buf, formatCSV, bookings); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,version,hotel_id,arrive,leave,name,status\na,0,123,,,Jane Guest,confirmed\nb,0,123,,,'=HYPERLINK(),cancelled\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("want=%q, got=%q", want, got)
+	}
+}
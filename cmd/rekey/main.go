@@ -0,0 +1,62 @@
+// Command rekey re-encrypts guest personal data and webhook secrets under the
+// active key of the keyring. Run it after adding a new master key to the key
+// file and making it active; once it completes, the previous key can be
+// removed from the file.
+//
+// It must connect as the owner of the bookings table, rather than the
+// service's own role, as row-level security would otherwise hide every
+// booking from it.
+//
+// The database is configured as the service's is, by -db flags, DB_
+// environment variables or the service's config file.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/form3tech/innsecure/config"
+	"github.com/form3tech/innsecure/keyring"
+	"github.com/form3tech/innsecure/postgres"
+	"github.com/go-kit/kit/log"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	var (
+		keyFile   = flag.String("keys", os.Getenv("KEYRING_FILE"), "Path to the keyring file")
+		batchSize = flag.Int("batch", 100, "Number of bookings to re-encrypt per transaction")
+	)
+
+	logger := log.NewJSONLogger(os.Stderr)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	ctx := context.Background()
+
+	cfg, err := config.LoadDB(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+	keys, err := keyring.Load(*keyFile)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	db, err := postgres.NewConnection(ctx, cfg, logger)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	n, err := postgres.NewRepo(db, keys).Reencrypt(ctx, *batchSize)
+	logger.Log("active_key", keys.ActiveKeyID(), "reencrypted", n)
+	if err != nil {
+		logger.Log("err", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
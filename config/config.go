@@ -0,0 +1,433 @@
+// Package config loads the configuration of the booking service.
+//
+// Every setting has a name, such as "db.host", and is taken from the first
+// of these to set it:
+//
+//   - the command line flag of that name, such as -db.host;
+//   - the environment variable named after it, such as DB_HOST;
+//   - the JSON config file given by -config or CONFIG_FILE, an object keyed
+//     by name, such as {"db.host": "db", "db.port": 5432};
+//   - the default.
+//
+// Secrets may instead be read from the file named by the environment
+// variable with a _FILE suffix, such as DB_PASSWORD_FILE, as Docker secrets
+// are.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/form3tech/innsecure/postgres"
+)
+
+// secrets are the names of the settings which are never printed, and may be
+// read from files.
+var secrets = map[string]bool{
+	"db.password":        true,
+	"jwt.signing-string": true,
+}
+
+// Config is the configuration of the booking service.
+type Config struct {
+	HTTP  HTTP
+	GRPC  GRPC
+	Admin Admin
+
+	DB      postgres.Config
+	Replica Replica
+
+	// JWTSigningString is the key with which bearer tokens are signed.
+	JWTSigningString string
+	// KeyringFile is the path to the keyring encrypting guests' personal
+	// data.
+	KeyringFile string
+
+	Retention      Retention
+	IdempotencyTTL time.Duration
+	Webhooks       Webhooks
+	Trace          Trace
+
+	// ShutdownDelay is how long to report not ready before shutting down.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout limits how long to wait for in-flight requests and
+	// background jobs to finish once shutting down.
+	ShutdownTimeout time.Duration
+}
+
+// HTTP configures the HTTP API.
+type HTTP struct {
+	Addr              string
+	Deprecations      string
+	CORSOrigins       []string
+	HSTS              time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+}
+
+// GRPC configures the gRPC API.
+type GRPC struct {
+	Addr string
+}
+
+// Admin configures the listener for health checks and metrics.
+type Admin struct {
+	Addr string
+}
+
+// Replica configures an optional read replica of the database, which shares
+// the primary's credentials and settings.
+type Replica struct {
+	// Host is the replica's host, or empty if there is none.
+	Host string
+	// Port is the replica's port, or 0 for the primary's.
+	Port   int
+	MaxLag time.Duration
+}
+
+// Retention configures how long data is kept.
+type Retention struct {
+	Days     int
+	Interval time.Duration
+	Events   time.Duration
+}
+
+// Webhooks configures the delivery of webhooks.
+type Webhooks struct {
+	Interval     time.Duration
+	AllowPrivate bool
+}
+
+// Trace configures where spans are exported.
+type Trace struct {
+	Exporter string
+	File     string
+}
+
+// Default returns the configuration used for settings which are not set.
+// It is not valid as it stands: the database host, JWT signing string and
+// keyring file have no defaults.
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:              ":8080",
+			HSTS:              365 * 24 * time.Hour,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+		},
+		GRPC:  GRPC{Addr: ":8081"},
+		Admin: Admin{Addr: ":8082"},
+		DB:    postgres.DefaultConfig(),
+		Replica: Replica{
+			MaxLag: 5 * time.Second,
+		},
+		Retention: Retention{
+			Days:     365,
+			Interval: time.Hour,
+			Events:   7 * 24 * time.Hour,
+		},
+		IdempotencyTTL: 24 * time.Hour,
+		Webhooks: Webhooks{
+			Interval: 5 * time.Second,
+		},
+		Trace:           Trace{Exporter: "none"},
+		ShutdownDelay:   5 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// flags defines a flag for each setting of c, bound to it.
+func (c *Config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.HTTP.Addr, "http.addr", c.HTTP.Addr, "HTTP listen address")
+	fs.StringVar(&c.HTTP.Deprecations, "http.deprecations", c.HTTP.Deprecations, "JSON file of deprecated HTTP routes, keyed by method and path")
+	fs.Var((*stringList)(&c.HTTP.CORSOrigins), "http.cors-origins", "Comma-separated origins of browser apps allowed to call the API, or * for any")
+	fs.DurationVar(&c.HTTP.HSTS, "http.hsts", c.HTTP.HSTS, "Max age of Strict-Transport-Security, or 0 not to send it")
+	fs.DurationVar(&c.HTTP.ReadHeaderTimeout, "http.read-header-timeout", c.HTTP.ReadHeaderTimeout, "How long to wait for the headers of an HTTP request")
+	fs.DurationVar(&c.HTTP.WriteTimeout, "http.write-timeout", c.HTTP.WriteTimeout, "How long to allow for an HTTP response, other than streamed exports and events")
+	fs.DurationVar(&c.HTTP.IdleTimeout, "http.idle-timeout", c.HTTP.IdleTimeout, "How long to keep idle HTTP connections open")
+	fs.StringVar(&c.GRPC.Addr, "grpc.addr", c.GRPC.Addr, "gRPC listen address")
+	fs.StringVar(&c.Admin.Addr, "admin.addr", c.Admin.Addr, "Admin HTTP listen address, for health checks and metrics")
+
+	c.dbFlags(fs)
+	fs.StringVar(&c.Replica.Host, "db.replica-host", c.Replica.Host, "Host of a read replica of the database, if there is one")
+	fs.IntVar(&c.Replica.Port, "db.replica-port", c.Replica.Port, "Port of the read replica, if not the primary's")
+	fs.DurationVar(&c.Replica.MaxLag, "db.replica-max-lag", c.Replica.MaxLag, "Replication lag beyond which reads go to the primary")
+
+	fs.StringVar(&c.JWTSigningString, "jwt.signing-string", c.JWTSigningString, "Key with which bearer tokens are signed")
+	fs.StringVar(&c.KeyringFile, "keyring.file", c.KeyringFile, "Path to the keyring encrypting guests' personal data")
+
+	fs.IntVar(&c.Retention.Days, "retention.days", c.Retention.Days, "Days after departure to keep guest data, unless the hotel sets its own period")
+	fs.DurationVar(&c.Retention.Interval, "retention.interval", c.Retention.Interval, "How often to erase guest data past its retention period")
+	fs.DurationVar(&c.Retention.Events, "events.retention", c.Retention.Events, "How long to keep booking events for change feeds to resume from")
+	fs.DurationVar(&c.IdempotencyTTL, "idempotency.ttl", c.IdempotencyTTL, "How long to remember the response to a request with an Idempotency-Key")
+	fs.DurationVar(&c.Webhooks.Interval, "webhooks.interval", c.Webhooks.Interval, "How often to check for webhook deliveries which are due")
+	fs.BoolVar(&c.Webhooks.AllowPrivate, "webhooks.allow-private", c.Webhooks.AllowPrivate, "Allow webhooks to use plain HTTP and internal addresses, for development")
+	fs.StringVar(&c.Trace.Exporter, "trace.exporter", c.Trace.Exporter, "Where to export spans: none, log, or json")
+	fs.StringVar(&c.Trace.File, "trace.file", c.Trace.File, "File to append spans to as JSON lines with -trace.exporter=json, rather than stdout")
+	fs.DurationVar(&c.ShutdownDelay, "shutdown.delay", c.ShutdownDelay, "How long to report not ready before shutting down, so that load balancers stop sending requests")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown.timeout", c.ShutdownTimeout, "How long to wait for in-flight requests and background jobs to finish when shutting down")
+}
+
+// dbFlags defines a flag for each setting of the database, bound to c.DB.
+func (c *Config) dbFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DB.Host, "db.host", c.DB.Host, "Database host")
+	fs.IntVar(&c.DB.Port, "db.port", c.DB.Port, "Database port")
+	fs.StringVar(&c.DB.User, "db.user", c.DB.User, "Database user")
+	fs.StringVar(&c.DB.Password, "db.password", c.DB.Password, "Database password")
+	fs.StringVar(&c.DB.Database, "db.name", c.DB.Database, "Database name")
+	fs.StringVar(&c.DB.SSLMode, "db.sslmode", c.DB.SSLMode, "Database sslmode: disable, require, verify-ca or verify-full")
+	fs.StringVar(&c.DB.RootCert, "db.sslrootcert", c.DB.RootCert, "CA certificates verifying the database, rather than the system roots")
+	fs.IntVar(&c.DB.MaxOpenConns, "db.max-open-conns", c.DB.MaxOpenConns, "Maximum number of open connections to the database")
+	fs.IntVar(&c.DB.MaxIdleConns, "db.max-idle-conns", c.DB.MaxIdleConns, "Maximum number of idle connections to the database")
+	fs.DurationVar(&c.DB.ConnMaxLifetime, "db.conn-max-lifetime", c.DB.ConnMaxLifetime, "How long to reuse a connection to the database")
+	fs.DurationVar(&c.DB.ConnMaxIdleTime, "db.conn-max-idle-time", c.DB.ConnMaxIdleTime, "How long to keep an idle connection to the database")
+	fs.DurationVar(&c.DB.ConnectTimeout, "db.connect-timeout", c.DB.ConnectTimeout, "How long to wait for the database to become reachable at startup")
+}
+
+// settings are the names of every setting.
+var settings = func() map[string]bool {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	c := Default()
+	c.flags(fs)
+	names := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) { names[f.Name] = true })
+	return names
+}()
+
+// Load returns the configuration given by the command line arguments, the
+// environment, as read by lookupEnv, and the config file, merged over the
+// defaults, and validated.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.flags(fs)
+	if err := parse(fs, args, lookupEnv); err != nil {
+		return nil, err
+	}
+	return &c, c.Validate()
+}
+
+// LoadDB returns the database configuration given as Load does, for commands
+// which need only the database. Its flags are defined on fs, which may have
+// the command's own flags too, and the config file may hold settings of the
+// service which are ignored.
+func LoadDB(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (postgres.Config, error) {
+	c := Default()
+	c.dbFlags(fs)
+	if err := parse(fs, args, lookupEnv); err != nil {
+		return c.DB, err
+	}
+	return c.DB, c.DB.Validate()
+}
+
+// parse sets the settings bound to the flags of fs from the command line
+// arguments, the environment and the config file.
+func parse(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+	path, _ := lookupEnv("CONFIG_FILE")
+	fs.StringVar(&path, "config", path, "JSON config file of settings keyed by flag name (env CONFIG_FILE)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %q", fs.Args())
+	}
+
+	// Flags are set already, so take precedence over the environment,
+	// which is applied after the file so as to take precedence over it.
+	set := map[string]bool{"config": true}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if path != "" {
+		if err := applyFile(fs, set, path); err != nil {
+			return err
+		}
+	}
+	return applyEnv(fs, set, lookupEnv)
+}
+
+// applyFile sets the settings in the config file at path which are bound to
+// flags of fs and have not been set by them.
+func applyFile(fs *flag.FlagSet, set map[string]bool, path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, v := range values {
+		if !settings[name] {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		f := fs.Lookup(name)
+		if f == nil || set[name] {
+			continue
+		}
+		// Strings are given unquoted, lists joined with commas, and
+		// numbers and booleans as they are.
+		var s string
+		var list []string
+		if err := json.Unmarshal(v, &s); err == nil {
+		} else if err := json.Unmarshal(v, &list); err == nil {
+			s = strings.Join(list, ",")
+		} else {
+			s = string(v)
+		}
+		if err := f.Value.Set(s); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// applyEnv sets the settings bound to flags of fs which have not been set by
+// them from their environment variables, and secrets from the files named by their _FILE
+// variants.
+func applyEnv(fs *flag.FlagSet, set map[string]bool, lookupEnv func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || !settings[f.Name] {
+			return
+		}
+		key := EnvVar(f.Name)
+		v, ok := lookupEnv(key)
+		if secrets[f.Name] {
+			if path, fromFile := lookupEnv(key + "_FILE"); fromFile {
+				if ok {
+					err = fmt.Errorf("only one of %s and %s_FILE may be set", key, key)
+					return
+				}
+				var raw []byte
+				if raw, err = ioutil.ReadFile(path); err != nil {
+					err = fmt.Errorf("%s_FILE: %w", key, err)
+					return
+				}
+				v, ok = strings.TrimRight(string(raw), "\r\n"), true
+			}
+		}
+		if !ok {
+			return
+		}
+		if serr := f.Value.Set(v); serr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", key, serr)
+		}
+	})
+	return err
+}
+
+// EnvVar returns the name of the environment variable of a setting, such as
+// DB_MAX_OPEN_CONNS for "db.max-open-conns".
+func EnvVar(name string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// Validate reports whether the configuration is usable, and whether its
+// settings make sense together.
+func (c *Config) Validate() error {
+	if err := c.DB.Validate(); err != nil {
+		return err
+	}
+	if c.Replica.Host != "" {
+		if _, err := c.ReplicaDB(); err != nil {
+			return err
+		}
+	} else if c.Replica.Port != 0 {
+		return errors.New("db.replica-port is set without db.replica-host")
+	}
+
+	switch {
+	case c.JWTSigningString == "":
+		return errors.New("jwt.signing-string is required")
+	case c.KeyringFile == "":
+		return errors.New("keyring.file is required")
+	case c.HTTP.Addr == "" || c.GRPC.Addr == "" || c.Admin.Addr == "":
+		return errors.New("http.addr, grpc.addr and admin.addr are required")
+	case c.HTTP.Addr == c.GRPC.Addr || c.HTTP.Addr == c.Admin.Addr || c.GRPC.Addr == c.Admin.Addr:
+		return errors.New("http.addr, grpc.addr and admin.addr must differ")
+	case c.HTTP.ReadHeaderTimeout <= 0 || c.HTTP.WriteTimeout <= 0 || c.HTTP.IdleTimeout <= 0:
+		return errors.New("HTTP timeouts must be positive")
+	case c.HTTP.HSTS < 0:
+		return errors.New("http.hsts must not be negative")
+	case c.Replica.MaxLag <= 0:
+		return errors.New("db.replica-max-lag must be positive")
+	case c.Retention.Days <= 0:
+		return errors.New("retention.days must be positive")
+	case c.Retention.Interval <= 0 || c.Retention.Events <= 0:
+		return errors.New("retention.interval and events.retention must be positive")
+	case c.IdempotencyTTL <= 0:
+		return errors.New("idempotency.ttl must be positive")
+	case c.Webhooks.Interval <= 0:
+		return errors.New("webhooks.interval must be positive")
+	case c.ShutdownDelay < 0:
+		return errors.New("shutdown.delay must not be negative")
+	case c.ShutdownTimeout <= 0:
+		return errors.New("shutdown.timeout must be positive")
+	}
+
+	switch c.Trace.Exporter {
+	case "none", "log", "json":
+	default:
+		return fmt.Errorf("unknown trace.exporter %q", c.Trace.Exporter)
+	}
+	if c.Trace.File != "" && c.Trace.Exporter != "json" {
+		return errors.New("trace.file is only used with trace.exporter json")
+	}
+	return nil
+}
+
+// ReplicaDB returns the configuration of the read replica, if there is one.
+func (c *Config) ReplicaDB() (postgres.Config, error) {
+	db := c.DB
+	db.Host = c.Replica.Host
+	if c.Replica.Port != 0 {
+		db.Port = c.Replica.Port
+	}
+	if err := db.Validate(); err != nil {
+		return db, fmt.Errorf("replica: %w", err)
+	}
+	return db, nil
+}
+
+// Redacted returns the settings as alternating names and values, in order
+// of name, for logging. Secrets which are set are replaced by "REDACTED".
+func (c *Config) Redacted() []interface{} {
+	cfg := *c
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	cfg.flags(fs)
+	var keyvals []interface{}
+	fs.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if secrets[f.Name] && v != "" {
+			v = "REDACTED"
+		}
+		keyvals = append(keyvals, f.Name, v)
+	})
+	return keyvals
+}
+
+// stringList is a flag.Value of a comma-separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
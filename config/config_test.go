@@ -0,0 +1,162 @@
+package config_test
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure/config"
+)
+
+// env returns a lookupEnv function reading from vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+}
+
+// writeFile writes a temporary file and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// required are environment variables setting the settings without defaults.
+func required() map[string]string {
+	return map[string]string{
+		"DB_HOST":            "db",
+		"JWT_SIGNING_STRING": "signing",
+		"KEYRING_FILE":       "/keys/keyring.json",
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.json", `{
+		"db.port": 6432,
+		"db.user": "from_file",
+		"db.name": "from_file",
+		"http.cors-origins": ["https://a.example.com", "https://b.example.com"],
+		"webhooks.allow-private": true,
+		"retention.days": 30
+	}`)
+	vars := required()
+	vars["CONFIG_FILE"] = file
+	vars["DB_USER"] = "from_env"
+	vars["DB_NAME"] = "from_env"
+	vars["RETENTION_INTERVAL"] = "10m"
+
+	cfg, err := config.Load("innsecure", []string{"-db.name=from_flag"}, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default", cfg.HTTP.Addr, ":8080"},
+		{"file", cfg.DB.Port, 6432},
+		{"file list", strings.Join(cfg.HTTP.CORSOrigins, " "), "https://a.example.com https://b.example.com"},
+		{"file bool", cfg.Webhooks.AllowPrivate, true},
+		{"file int", cfg.Retention.Days, 30},
+		{"env over file", cfg.DB.User, "from_env"},
+		{"env duration", cfg.Retention.Interval, 10 * time.Minute},
+		{"flag over env", cfg.DB.Database, "from_flag"},
+		{"env secret", cfg.JWTSigningString, "signing"},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: want=%v, got=%v", c.name, c.want, c.got)
+		}
+	}
+}
+
+func TestLoadSecretsFromFiles(t *testing.T) {
+	vars := required()
+	delete(vars, "JWT_SIGNING_STRING")
+	vars["JWT_SIGNING_STRING_FILE"] = writeFile(t, "jwt", "from-secret\n")
+	vars["DB_PASSWORD_FILE"] = writeFile(t, "password", "s3cret")
+
+	cfg, err := config.Load("innsecure", nil, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.JWTSigningString != "from-secret" || cfg.DB.Password != "s3cret" {
+		t.Fatalf("want secrets read from files, got %q and %q", cfg.JWTSigningString, cfg.DB.Password)
+	}
+
+	redacted := fmt.Sprintln(cfg.Redacted()...)
+	if strings.Contains(redacted, "from-secret") || strings.Contains(redacted, "s3cret") || !strings.Contains(redacted, "db.host db") {
+		t.Fatalf("want secrets redacted from %s", redacted)
+	}
+
+	vars["DB_PASSWORD"] = "also"
+	if _, err := config.Load("innsecure", nil, env(vars)); err == nil || !strings.Contains(err.Error(), "DB_PASSWORD_FILE") {
+		t.Fatalf("want a secret set twice rejected, got %v", err)
+	}
+}
+
+func TestLoadDB(t *testing.T) {
+	file := writeFile(t, "config.json", `{"db.host": "from_file", "db.port": 6432, "http.addr": ":9090"}`)
+	vars := map[string]string{"CONFIG_FILE": file, "DB_USER": "from_env", "BATCH": "5"}
+
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	batch := fs.Int("batch", 100, "")
+	db, err := config.LoadDB(fs, []string{"-db.name=from_flag"}, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Host != "from_file" || db.Port != 6432 || db.User != "from_env" || db.Database != "from_flag" || db.SSLMode != "verify-full" {
+		t.Fatalf("want the database configured as the service's is, got %+v", db)
+	}
+	if *batch != 100 {
+		t.Fatalf("want the command's own flags not set from the environment, got %d", *batch)
+	}
+
+	fs = flag.NewFlagSet("rekey", flag.ContinueOnError)
+	if _, err := config.LoadDB(fs, []string{"-config", writeFile(t, "bad.json", `{"db.hots": "db"}`)}, env(nil)); err == nil || !strings.Contains(err.Error(), `unknown setting "db.hots"`) {
+		t.Fatalf("want unknown settings rejected, got %v", err)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{name: "no signing string", env: map[string]string{"JWT_SIGNING_STRING": ""}, want: "jwt.signing-string is required"},
+		{name: "no database host", env: map[string]string{"DB_HOST": ""}, want: "database host is required"},
+		{name: "bad duration", env: map[string]string{"IDEMPOTENCY_TTL": "a day"}, want: "IDEMPOTENCY_TTL"},
+		{name: "same address", args: []string{"-admin.addr=:8080"}, want: "must differ"},
+		{name: "replica port without host", args: []string{"-db.replica-port=5433"}, want: "without db.replica-host"},
+		{name: "bad replica", args: []string{"-db.replica-host=replica", "-db.replica-port=70000"}, want: "replica"},
+		{name: "trace file without json", args: []string{"-trace.exporter=log", "-trace.file=spans.json"}, want: "trace.file"},
+		{name: "unknown file setting", file: `{"db.hots": "db"}`, want: `unknown setting "db.hots"`},
+		{name: "unknown flag", args: []string{"-db.hots=db"}, want: "db.hots"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vars := required()
+			for k, v := range c.env {
+				vars[k] = v
+			}
+			args := c.args
+			if c.file != "" {
+				args = append(args, "-config", writeFile(t, "config.json", c.file))
+			}
+			_, err := config.Load("innsecure", args, env(vars))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("want error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
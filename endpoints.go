@@ -3,7 +3,10 @@ package innsecure
 import (
 	"context"
 	"errors"
+	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 )
 
@@ -14,9 +17,26 @@ type Endpoints struct {
 	ListBookings   endpoint.Endpoint
 	CreateBooking  endpoint.Endpoint
 	GetBookingByID endpoint.Endpoint
+	EraseGuest     endpoint.Endpoint
+	CancelBooking  endpoint.Endpoint
+	ImportBookings endpoint.Endpoint
+	ExportBookings endpoint.Endpoint
+	WatchBookings  endpoint.Endpoint
+
+	CreateFeedToken endpoint.Endpoint
+	ListFeedTokens  endpoint.Endpoint
+	RevokeFeedToken endpoint.Endpoint
+	FeedBookings    endpoint.Endpoint
+
+	CreateWebhook         endpoint.Endpoint
+	ListWebhooks          endpoint.Endpoint
+	DeleteWebhook         endpoint.Endpoint
+	ListWebhookDeliveries endpoint.Endpoint
 }
 
-func contextToUser(ctx context.Context) *User {
+// UserFromContext returns the authenticated user, or nil if the request has
+// not been authenticated.
+func UserFromContext(ctx context.Context) *User {
 	u, ok := ctx.Value(UserContextKey).(*User)
 	if !ok {
 		return nil
@@ -24,21 +44,89 @@ func contextToUser(ctx context.Context) *User {
 	return u
 }
 
+// EndpointsOption configures MakeServerEndpoints.
+type EndpointsOption func(*endpointsOptions)
+
+type endpointsOptions struct {
+	idempotency endpoint.Middleware
+}
+
+// WithIdempotency makes CreateBooking requests which carry an idempotency key
+// create their booking only once, remembering them in store for ttl (see
+// IdempotentCreateBooking).
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) EndpointsOption {
+	return func(o *endpointsOptions) {
+		o.idempotency = IdempotentCreateBooking(store, ttl)
+	}
+}
+
 // MakeServerEndpoints returns an Endpoints struct where each endpoint invokes
 // the corresponding method on the provided service.
-func MakeServerEndpoints(s Service, jwtmw endpoint.Middleware) Endpoints {
+func MakeServerEndpoints(s Service, jwtmw endpoint.Middleware, opts ...EndpointsOption) Endpoints {
+	var o endpointsOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	createBooking := MakeCreateBookingEndpoint(s)
+	if o.idempotency != nil {
+		// Idempotency keys belong to the authenticated user, so are looked
+		// up after authentication.
+		createBooking = o.idempotency(createBooking)
+	}
 
 	return Endpoints{
 		ListBookings:   jwtmw(MakeListBookingsEndpoint(s)),
-		CreateBooking:  jwtmw(MakeCreateBookingEndpoint(s)),
+		CreateBooking:  jwtmw(createBooking),
 		GetBookingByID: jwtmw(MakeGetBookingByIDEndpoint(s)),
+		EraseGuest:     jwtmw(MakeEraseGuestEndpoint(s)),
+		CancelBooking:  jwtmw(MakeCancelBookingEndpoint(s)),
+		ImportBookings: jwtmw(MakeImportBookingsEndpoint(s)),
+		ExportBookings: jwtmw(MakeExportBookingsEndpoint(s)),
+		WatchBookings:  jwtmw(MakeWatchBookingsEndpoint(s)),
+
+		CreateFeedToken: jwtmw(MakeCreateFeedTokenEndpoint(s)),
+		ListFeedTokens:  jwtmw(MakeListFeedTokensEndpoint(s)),
+		RevokeFeedToken: jwtmw(MakeRevokeFeedTokenEndpoint(s)),
+		// Feeds are read by calendar clients, which authenticate with a
+		// feed token rather than a JWT.
+		FeedBookings: MakeFeedBookingsEndpoint(s),
+
+		CreateWebhook:         jwtmw(MakeCreateWebhookEndpoint(s)),
+		ListWebhooks:          jwtmw(MakeListWebhooksEndpoint(s)),
+		DeleteWebhook:         jwtmw(MakeDeleteWebhookEndpoint(s)),
+		ListWebhookDeliveries: jwtmw(MakeListWebhookDeliveriesEndpoint(s)),
+	}
+}
+
+// With returns e with each endpoint wrapped in the middleware returned by mw
+// for its name, such as "CreateBooking".
+func (e Endpoints) With(mw func(method string) endpoint.Middleware) Endpoints {
+	return Endpoints{
+		ListBookings:   mw("ListBookings")(e.ListBookings),
+		CreateBooking:  mw("CreateBooking")(e.CreateBooking),
+		GetBookingByID: mw("GetBookingByID")(e.GetBookingByID),
+		EraseGuest:     mw("EraseGuest")(e.EraseGuest),
+		CancelBooking:  mw("CancelBooking")(e.CancelBooking),
+		ImportBookings: mw("ImportBookings")(e.ImportBookings),
+		ExportBookings: mw("ExportBookings")(e.ExportBookings),
+		WatchBookings:  mw("WatchBookings")(e.WatchBookings),
+
+		CreateFeedToken: mw("CreateFeedToken")(e.CreateFeedToken),
+		ListFeedTokens:  mw("ListFeedTokens")(e.ListFeedTokens),
+		RevokeFeedToken: mw("RevokeFeedToken")(e.RevokeFeedToken),
+		FeedBookings:    mw("FeedBookings")(e.FeedBookings),
+
+		CreateWebhook:         mw("CreateWebhook")(e.CreateWebhook),
+		ListWebhooks:          mw("ListWebhooks")(e.ListWebhooks),
+		DeleteWebhook:         mw("DeleteWebhook")(e.DeleteWebhook),
+		ListWebhookDeliveries: mw("ListWebhookDeliveries")(e.ListWebhookDeliveries),
 	}
 }
 
 // MakeListBookingsEndpoint returns an endpoint wrapping the given server.
 func MakeListBookingsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, _ interface{}) (response interface{}, err error) {
-		u := contextToUser(ctx)
+		u := UserFromContext(ctx)
 		return s.ListBookings(ctx, u)
 	}
 }
@@ -50,7 +138,7 @@ func MakeCreateBookingEndpoint(s Service) endpoint.Endpoint {
 		if !ok {
 			return nil, errors.New("invalid request type, likely bad wiring")
 		}
-		u := contextToUser(ctx)
+		u := UserFromContext(ctx)
 
 		return s.CreateBooking(ctx, u, b)
 	}
@@ -63,7 +151,178 @@ func MakeGetBookingByIDEndpoint(s Service) endpoint.Endpoint {
 		if !ok {
 			return nil, errors.New("invalid request type, likely bad wiring")
 		}
-		u := contextToUser(ctx)
+		u := UserFromContext(ctx)
 		return s.GetBookingByID(ctx, u, id)
 	}
 }
+
+// MakeEraseGuestEndpoint returns an endpoint wrapping the given server.
+func MakeEraseGuestEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		r, ok := request.(ErasureRequest)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.EraseGuest(ctx, u, r)
+	}
+}
+
+// MakeCancelBookingEndpoint returns an endpoint wrapping the given server.
+func MakeCancelBookingEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		id, ok := request.(string)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.CancelBooking(ctx, u, id)
+	}
+}
+
+// MakeImportBookingsEndpoint returns an endpoint wrapping the given server.
+func MakeImportBookingsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		rows, ok := request.([]ImportRow)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.ImportBookings(ctx, u, rows)
+	}
+}
+
+// BookingStream calls fn with each booking of a stream in turn, stopping at
+// the first error.
+type BookingStream func(fn func(Booking) error) error
+
+// MakeExportBookingsEndpoint returns an endpoint wrapping the given server.
+// Its response is a BookingStream, so that bookings are exported as they are
+// read rather than all at once.
+func MakeExportBookingsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (response interface{}, err error) {
+		u := UserFromContext(ctx)
+		return BookingStream(func(fn func(Booking) error) error {
+			return s.ExportBookings(ctx, u, fn)
+		}), nil
+	}
+}
+
+// MakeCreateFeedTokenEndpoint returns an endpoint wrapping the given server.
+func MakeCreateFeedTokenEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (response interface{}, err error) {
+		u := UserFromContext(ctx)
+		return s.CreateFeedToken(ctx, u)
+	}
+}
+
+// MakeListFeedTokensEndpoint returns an endpoint wrapping the given server.
+func MakeListFeedTokensEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (response interface{}, err error) {
+		u := UserFromContext(ctx)
+		return s.ListFeedTokens(ctx, u)
+	}
+}
+
+// MakeRevokeFeedTokenEndpoint returns an endpoint wrapping the given server.
+func MakeRevokeFeedTokenEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		id, ok := request.(string)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.RevokeFeedToken(ctx, u, id)
+	}
+}
+
+// FeedRequest asks for a hotel's bookings with a feed token.
+type FeedRequest struct {
+	HotelID int
+	Token   string
+}
+
+// MakeFeedBookingsEndpoint returns an endpoint wrapping the given server.
+func MakeFeedBookingsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		r, ok := request.(FeedRequest)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		return s.FeedBookings(ctx, r.HotelID, r.Token)
+	}
+}
+
+// EventStream calls fn with each event of a stream in turn, until the stream
+// ends or fn returns an error.
+type EventStream func(fn func(BookingEvent) error) error
+
+// MakeWatchBookingsEndpoint returns an endpoint wrapping the given server.
+// Its request is the ID of the last event the client has seen, or -1, and its
+// response is an EventStream. The user is authorised once, when the stream
+// starts, so the stream ends when their token expires.
+func MakeWatchBookingsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		after, ok := request.(int64)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return EventStream(func(fn func(BookingEvent) error) error {
+			streamCtx := ctx
+			if claims, ok := ctx.Value(jwt.JWTClaimsContextKey).(stdjwt.MapClaims); ok {
+				if exp, ok := claims["exp"].(float64); ok {
+					var cancel context.CancelFunc
+					streamCtx, cancel = context.WithDeadline(ctx, time.Unix(int64(exp), 0))
+					defer cancel()
+				}
+			}
+			return s.WatchBookings(streamCtx, u, after, fn)
+		}), nil
+	}
+}
+
+// MakeCreateWebhookEndpoint returns an endpoint wrapping the given server.
+func MakeCreateWebhookEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		w, ok := request.(Webhook)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.CreateWebhook(ctx, u, w)
+	}
+}
+
+// MakeListWebhooksEndpoint returns an endpoint wrapping the given server.
+func MakeListWebhooksEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (response interface{}, err error) {
+		u := UserFromContext(ctx)
+		return s.ListWebhooks(ctx, u)
+	}
+}
+
+// MakeDeleteWebhookEndpoint returns an endpoint wrapping the given server.
+func MakeDeleteWebhookEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		id, ok := request.(string)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.DeleteWebhook(ctx, u, id)
+	}
+}
+
+// MakeListWebhookDeliveriesEndpoint returns an endpoint wrapping the given
+// server.
+func MakeListWebhookDeliveriesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		id, ok := request.(string)
+		if !ok {
+			return nil, errors.New("invalid request type, likely bad wiring")
+		}
+		u := UserFromContext(ctx)
+		return s.ListWebhookDeliveries(ctx, u, id)
+	}
+}
@@ -17,6 +17,21 @@ type svc struct {
 	listBookings   func(ctx context.Context, u *innsecure.User) (listing *innsecure.Listing, err error)
 	createBooking  func(ctx context.Context, p innsecure.Booking) (*innsecure.Booking, error)
 	getBookingByID func(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Booking, error)
+	eraseGuest     func(ctx context.Context, u *innsecure.User, r innsecure.ErasureRequest) (*innsecure.Erasure, error)
+	cancelBooking  func(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Booking, error)
+	importBookings func(ctx context.Context, u *innsecure.User, rows []innsecure.ImportRow) (*innsecure.ImportReport, error)
+	exportBookings func(ctx context.Context, u *innsecure.User, fn func(innsecure.Booking) error) error
+
+	createFeedToken func(ctx context.Context, u *innsecure.User) (*innsecure.FeedToken, error)
+	listFeedTokens  func(ctx context.Context, u *innsecure.User) (*innsecure.FeedTokenListing, error)
+	revokeFeedToken func(ctx context.Context, u *innsecure.User, ID string) (*innsecure.FeedToken, error)
+	feedBookings    func(ctx context.Context, hotelID int, token string) (*innsecure.Listing, error)
+	watchBookings   func(ctx context.Context, u *innsecure.User, after int64, fn func(innsecure.BookingEvent) error) error
+
+	createWebhook         func(ctx context.Context, u *innsecure.User, w innsecure.Webhook) (*innsecure.Webhook, error)
+	listWebhooks          func(ctx context.Context, u *innsecure.User) (*innsecure.WebhookListing, error)
+	deleteWebhook         func(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Webhook, error)
+	listWebhookDeliveries func(ctx context.Context, u *innsecure.User, webhookID string) (*innsecure.WebhookDeliveryListing, error)
 }
 
 func (s svc) ListBookings(ctx context.Context, u *innsecure.User) (listing *innsecure.Listing, err error) {
@@ -29,6 +44,53 @@ func (s svc) GetBookingByID(ctx context.Context, u *innsecure.User, ID string) (
 	return s.getBookingByID(ctx, u, ID)
 }
 
+func (s svc) EraseGuest(ctx context.Context, u *innsecure.User, r innsecure.ErasureRequest) (*innsecure.Erasure, error) {
+	return s.eraseGuest(ctx, u, r)
+}
+
+func (s svc) CancelBooking(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Booking, error) {
+	return s.cancelBooking(ctx, u, ID)
+}
+
+func (s svc) ImportBookings(ctx context.Context, u *innsecure.User, rows []innsecure.ImportRow) (*innsecure.ImportReport, error) {
+	return s.importBookings(ctx, u, rows)
+}
+
+func (s svc) ExportBookings(ctx context.Context, u *innsecure.User, fn func(innsecure.Booking) error) error {
+	return s.exportBookings(ctx, u, fn)
+}
+
+func (s svc) CreateFeedToken(ctx context.Context, u *innsecure.User) (*innsecure.FeedToken, error) {
+	return s.createFeedToken(ctx, u)
+}
+
+func (s svc) ListFeedTokens(ctx context.Context, u *innsecure.User) (*innsecure.FeedTokenListing, error) {
+	return s.listFeedTokens(ctx, u)
+}
+
+func (s svc) RevokeFeedToken(ctx context.Context, u *innsecure.User, ID string) (*innsecure.FeedToken, error) {
+	return s.revokeFeedToken(ctx, u, ID)
+}
+
+func (s svc) FeedBookings(ctx context.Context, hotelID int, token string) (*innsecure.Listing, error) {
+	return s.feedBookings(ctx, hotelID, token)
+}
+func (s svc) WatchBookings(ctx context.Context, u *innsecure.User, after int64, fn func(innsecure.BookingEvent) error) error {
+	return s.watchBookings(ctx, u, after, fn)
+}
+func (s svc) CreateWebhook(ctx context.Context, u *innsecure.User, w innsecure.Webhook) (*innsecure.Webhook, error) {
+	return s.createWebhook(ctx, u, w)
+}
+func (s svc) ListWebhooks(ctx context.Context, u *innsecure.User) (*innsecure.WebhookListing, error) {
+	return s.listWebhooks(ctx, u)
+}
+func (s svc) DeleteWebhook(ctx context.Context, u *innsecure.User, ID string) (*innsecure.Webhook, error) {
+	return s.deleteWebhook(ctx, u, ID)
+}
+func (s svc) ListWebhookDeliveries(ctx context.Context, u *innsecure.User, webhookID string) (*innsecure.WebhookDeliveryListing, error) {
+	return s.listWebhookDeliveries(ctx, u, webhookID)
+}
+
 func TestCanWrapList(t *testing.T) {
 	want := &innsecure.Listing{}
 	wantErr := errors.New("testerr")
@@ -93,3 +155,49 @@ func TestCanWrapGetByID(t *testing.T) {
 		t.Fatalf("want=%s, got=%s", wantErr, err)
 	}
 }
+
+func TestCanWrapEraseGuest(t *testing.T) {
+	wantIn := innsecure.ErasureRequest{Name: "Jane Guest"}
+	wantOut := &innsecure.Erasure{Erased: 2}
+	wantErr := errors.New("testerr")
+	service := svc{
+		eraseGuest: func(_ context.Context, _ *innsecure.User, in innsecure.ErasureRequest) (*innsecure.Erasure, error) {
+			if in != wantIn {
+				t.Fatalf("want=%+v, got=%+v", wantIn, in)
+			}
+			return wantOut, wantErr
+		},
+	}
+
+	sut := innsecure.MakeServerEndpoints(service, noopMiddleware)
+	got, err := sut.EraseGuest(context.TODO(), wantIn)
+	if got != wantOut {
+		t.Fatalf("want=%+v, got=%+v", wantOut, got)
+	}
+	if err != wantErr {
+		t.Fatalf("want=%s, got=%s", wantErr, err)
+	}
+}
+
+func TestCanWrapCancel(t *testing.T) {
+	wantIn := "INPUT"
+	wantOut := &innsecure.Booking{ID: "OUTPUT"}
+	wantErr := errors.New("testerr")
+	service := svc{
+		cancelBooking: func(_ context.Context, _ *innsecure.User, in string) (*innsecure.Booking, error) {
+			if in != wantIn {
+				t.Fatalf("want=%s, got=%s", wantIn, in)
+			}
+			return wantOut, wantErr
+		},
+	}
+
+	sut := innsecure.MakeServerEndpoints(service, noopMiddleware)
+	got, err := sut.CancelBooking(context.TODO(), wantIn)
+	if got != wantOut {
+		t.Fatalf("want=%+v, got=%+v", wantOut, got)
+	}
+	if err != wantErr {
+		t.Fatalf("want=%s, got=%s", wantErr, err)
+	}
+}
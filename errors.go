@@ -0,0 +1,123 @@
+package innsecure
+
+import (
+	"errors"
+	"net/http"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/auth/jwt"
+)
+
+// ProblemTypePrefix prefixes an Error's Code to form the RFC 7807 problem
+// type URI.
+const ProblemTypePrefix = "urn:innsecure:problem:"
+
+// Error is an error carrying everything needed to report it to a client.
+// Errors which are not already an *Error are converted with AsError.
+type Error struct {
+	// Code is a stable, machine readable identifier for the kind of error,
+	// such as "invalid_booking".
+	Code string
+	// Message describes the error for humans.
+	Message string
+	// Status is the HTTP status code.
+	Status int
+	// Fields lists the fields of the request which were rejected, if any.
+	Fields []FieldError
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// FieldError describes why the value of a single field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error satisfies error.
+func (e *Error) Error() string {
+	msg := e.Message
+	for i, f := range e.Fields {
+		if i == 0 {
+			msg += ": "
+		} else {
+			msg += "; "
+		}
+		msg += f.Field + " " + f.Message
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error, so that errors.Is(err, ErrNotFound)
+// and the like work for an *Error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// knownErrors lists the code and status used for each error returned by the
+// service or its transports.
+var knownErrors = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{ErrNotFound, "not_found", http.StatusNotFound},
+	{ErrBadRequest, "malformed_request", http.StatusBadRequest},
+	{ErrUnsupportedMediaType, "unsupported_media_type", http.StatusUnsupportedMediaType},
+	{ErrRequestTooLarge, "request_too_large", http.StatusRequestEntityTooLarge},
+	{ErrInvalidBooking, "invalid_booking", http.StatusBadRequest},
+	{ErrInvalidErasure, "invalid_erasure", http.StatusBadRequest},
+	{ErrInvalidWebhook, "invalid_webhook", http.StatusBadRequest},
+	{ErrUnauthorized, "unauthorized", http.StatusUnauthorized},
+	{ErrPreconditionFailed, "precondition_failed", http.StatusPreconditionFailed},
+	{ErrIdempotencyKeyReused, "idempotency_key_reused", http.StatusUnprocessableEntity},
+	{ErrIdempotencyKeyInUse, "idempotency_key_in_use", http.StatusConflict},
+	{ErrDatabase, "database_error", http.StatusInternalServerError},
+	{jwt.ErrTokenContextMissing, "missing_token", http.StatusUnauthorized},
+	{jwt.ErrTokenInvalid, "invalid_token", http.StatusUnauthorized},
+	{jwt.ErrTokenExpired, "invalid_token", http.StatusUnauthorized},
+	{jwt.ErrTokenMalformed, "invalid_token", http.StatusUnauthorized},
+	{jwt.ErrTokenNotActive, "invalid_token", http.StatusUnauthorized},
+	{jwt.ErrUnexpectedSigningMethod, "invalid_token", http.StatusUnauthorized},
+	{stdjwt.ErrSignatureInvalid, "invalid_token", http.StatusUnauthorized},
+}
+
+// AsError returns err as an *Error. Errors which are not known to the
+// service are reported as internal errors without further detail, so that
+// internals are not leaked to clients.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for _, k := range knownErrors {
+		if errors.Is(err, k.err) {
+			return &Error{Code: k.code, Message: k.err.Error(), Status: k.status, Err: err}
+		}
+	}
+	return &Error{
+		Code:    "internal_error",
+		Message: http.StatusText(http.StatusInternalServerError),
+		Status:  http.StatusInternalServerError,
+		Err:     err,
+	}
+}
+
+// ErrorForCode returns the error known to the service with the given code,
+// or nil if there is none. It is used by clients to recover domain errors.
+func ErrorForCode(code string) error {
+	for _, k := range knownErrors {
+		if k.code == code {
+			return k.err
+		}
+	}
+	return nil
+}
+
+// withFields returns err, which must be known to the service, annotated with
+// the fields which caused it.
+func withFields(err error, fields ...FieldError) *Error {
+	e := AsError(err)
+	e.Fields = fields
+	return e
+}
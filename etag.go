@@ -0,0 +1,97 @@
+package innsecure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrPreconditionFailed is returned if a booking has changed since the
+// version a conditional request was made against.
+const ErrPreconditionFailed ErrorString = "Precondition failed"
+
+// AnyVersion may be passed to Repository.Cancel to cancel a booking whatever
+// its version.
+const AnyVersion = -1
+
+// ETag returns the strong entity tag of the booking. Every change to a
+// booking increments its version, so the tag changes with it.
+func (b Booking) ETag() string {
+	return `"` + b.ID + "." + strconv.Itoa(b.Version) + `"`
+}
+
+// ETag returns a weak entity tag for the listing, which changes whenever a
+// booking is added to or removed from it, or changes.
+func (l Listing) ETag() string {
+	h := sha256.New()
+	for _, b := range l.Data {
+		h.Write([]byte(b.ETag()))
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+type conditionsKey struct{}
+
+// conditions are the preconditions of a request (RFC 7232).
+type conditions struct {
+	ifMatch     []string
+	ifNoneMatch []string
+}
+
+// WithIfMatch returns a context in which changes to a booking are only made
+// if its ETag is one of etags, or etags contains "*".
+func WithIfMatch(ctx context.Context, etags []string) context.Context {
+	c, _ := ctx.Value(conditionsKey{}).(conditions)
+	c.ifMatch = etags
+	return context.WithValue(ctx, conditionsKey{}, c)
+}
+
+// conditionsToContext moves the If-Match header of every request, and the
+// If-None-Match header of GET requests, into the context.
+func conditionsToContext(ctx context.Context, r *http.Request) context.Context {
+	var c conditions
+	if v := r.Header.Values("If-Match"); len(v) > 0 {
+		c.ifMatch = splitETags(v)
+	}
+	if v := r.Header.Values("If-None-Match"); len(v) > 0 && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		c.ifNoneMatch = splitETags(v)
+	}
+	if c.ifMatch == nil && c.ifNoneMatch == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, conditionsKey{}, c)
+}
+
+// splitETags splits the values of an If-Match or If-None-Match header into
+// entity tags.
+func splitETags(values []string) []string {
+	var etags []string
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				etags = append(etags, t)
+			}
+		}
+	}
+	return etags
+}
+
+// matchETag reports whether etag is one of etags, or etags contains "*". If
+// weak is true, weak and strong tags with the same value match.
+func matchETag(etags []string, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, t := range etags {
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
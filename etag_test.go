@@ -0,0 +1,71 @@
+package innsecure_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	booking := innsecure.Booking{ID: foundID, HotelID: 123, Version: 1}
+	r := repo{
+		list: func(_ context.Context, _ int) ([]innsecure.Booking, error) {
+			return []innsecure.Booking{booking}, nil
+		},
+		byID: func(_ context.Context, _ int, _ string) (*innsecure.Booking, error) {
+			b := booking
+			return &b, nil
+		},
+		cancel: func(_ context.Context, _ int, _ string, _ int) (*innsecure.Booking, error) {
+			b := booking
+			b.Version++
+			return &b, nil
+		},
+	}
+	e := innsecure.MakeServerEndpoints(innsecure.NewBookingService(r), asAdmin)
+	h := innsecure.MakeHTTPHandler(e, log.NewNopLogger())
+
+	do := func(method, path, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/hotels/123/bookings/"+foundID, "", "")
+	if got := w.Header().Get("ETag"); got != `"`+foundID+`.1"` {
+		t.Fatalf("want strong ETag for booking, got %q", got)
+	}
+	if w := do("GET", "/hotels/123/bookings/"+foundID, "If-None-Match", `"`+foundID+`.1"`); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("want empty %d, got %d with %q", http.StatusNotModified, w.Code, w.Body.String())
+	}
+	if w := do("GET", "/hotels/123/bookings/"+foundID, "If-None-Match", `"`+foundID+`.0"`); w.Code != http.StatusOK {
+		t.Fatalf("want=%d for stale ETag, got=%d", http.StatusOK, w.Code)
+	}
+
+	etag := do("GET", "/hotels/123/bookings", "", "").Header().Get("ETag")
+	if len(etag) < 2 || etag[:2] != "W/" {
+		t.Fatalf("want weak ETag for listing, got %q", etag)
+	}
+	if w := do("GET", "/hotels/123/bookings", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("want=%d, got=%d", http.StatusNotModified, w.Code)
+	}
+
+	if w := do("POST", "/hotels/123/bookings/"+foundID+"/cancel", "If-Match", `"`+foundID+`.0"`); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("want=%d for stale If-Match, got=%d", http.StatusPreconditionFailed, w.Code)
+	}
+	w = do("POST", "/hotels/123/bookings/"+foundID+"/cancel", "If-Match", `"`+foundID+`.1"`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("ETag"); got != `"`+foundID+`.2"` {
+		t.Fatalf("want ETag of cancelled booking, got %q", got)
+	}
+}
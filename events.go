@@ -0,0 +1,23 @@
+package innsecure
+
+import "time"
+
+// Booking event types.
+const (
+	EventCreated   = "created"
+	EventUpdated   = "updated"
+	EventCancelled = "cancelled"
+)
+
+// BookingEvent records a change to a booking. It identifies the booking, but
+// does not contain it, so that guest personal data is not kept in the event
+// history; clients fetch the booking if they need it.
+type BookingEvent struct {
+	// ID orders the events of a hotel. It increases with every event, but
+	// not necessarily by one.
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	BookingID  string    `json:"booking_id"`
+	Version    int       `json:"version"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
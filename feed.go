@@ -0,0 +1,45 @@
+package innsecure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+// feedTokenPrefix marks feed token secrets, so that they are easily
+// recognised, for instance by secret scanners.
+const feedTokenPrefix = "ift_"
+
+// FeedToken grants read-only access to a hotel's bookings as a calendar feed.
+type FeedToken struct {
+	ID      string `json:"id"`
+	HotelID int    `json:"hotel_id"`
+	// Token is the secret to pass as the token query parameter of the feed.
+	// It is only returned when the token is created.
+	Token     string     `json:"token,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// FeedTokenListing contains a list of feed tokens.
+type FeedTokenListing struct {
+	Data []FeedToken `json:"data"`
+}
+
+// newFeedTokenSecret returns a new random feed token secret.
+func newFeedTokenSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return feedTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashFeedToken returns the hash by which a feed token secret is stored. The
+// secrets are random, so need no salt or stretching.
+func hashFeedToken(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
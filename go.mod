@@ -9,4 +9,6 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.2
 	github.com/pborman/uuid v1.2.1
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,165 @@
+// Package health reports whether the service is up, alive and ready to serve
+// requests, for orchestrators and load balancers.
+//
+// Subsystems register checks with a Registry, which serves them on:
+//
+//	/healthz  200 whenever the process is up, without running any checks.
+//	/livez    503 if a liveness check fails, meaning the process is wedged
+//	          and should be restarted.
+//	/readyz   503 if a readiness check fails, meaning requests should not be
+//	          sent to the process for now, or once it is shutting down.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses reported by the endpoints.
+const (
+	StatusOK           = "ok"
+	StatusFailing      = "failing"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Checker checks a dependency of the service, returning an error if it is
+// not usable.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc is a Checker which calls a function.
+type CheckerFunc func(ctx context.Context) error
+
+// Check satisfies Checker.
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Report is the body of a /livez or /readyz response.
+type Report struct {
+	Status string `json:"status"`
+	// Checks maps the name of each check to StatusOK or its error.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Registry holds the checks of the service's subsystems.
+type Registry struct {
+	timeout      time.Duration
+	shuttingDown int32
+
+	mu        sync.RWMutex
+	readiness map[string]Checker
+	liveness  map[string]Checker
+}
+
+// NewRegistry returns an empty Registry, whose checks fail if they take
+// longer than timeout.
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		timeout:   timeout,
+		readiness: map[string]Checker{},
+		liveness:  map[string]Checker{},
+	}
+}
+
+// AddReadinessCheck adds a check which must pass for the service to be sent
+// requests, replacing any check of the same name.
+func (r *Registry) AddReadinessCheck(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readiness[name] = c
+}
+
+// AddLivenessCheck adds a check which fails only if the process cannot
+// recover without being restarted, replacing any check of the same name.
+func (r *Registry) AddLivenessCheck(name string, c Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.liveness[name] = c
+}
+
+// Shutdown marks the service as shutting down, after which it is never
+// ready, so that load balancers stop sending it requests while it finishes
+// those in flight.
+func (r *Registry) Shutdown() {
+	atomic.StoreInt32(&r.shuttingDown, 1)
+}
+
+// Ready runs the readiness checks.
+func (r *Registry) Ready(ctx context.Context) Report {
+	if atomic.LoadInt32(&r.shuttingDown) == 1 {
+		return Report{Status: StatusShuttingDown}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.run(ctx, r.readiness)
+}
+
+// Live runs the liveness checks.
+func (r *Registry) Live(ctx context.Context) Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.run(ctx, r.liveness)
+}
+
+// run runs checks concurrently, each with the registry's timeout.
+func (r *Registry) run(ctx context.Context, checks map[string]Checker) Report {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]string, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, c Checker) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, r.timeout)
+			defer cancel()
+			results[i] = StatusOK
+			if err := c.Check(ctx); err != nil {
+				results[i] = err.Error()
+			}
+		}(i, checks[name])
+	}
+	wg.Wait()
+
+	rep := Report{Status: StatusOK, Checks: map[string]string{}}
+	for i, name := range names {
+		rep.Checks[name] = results[i]
+		if results[i] != StatusOK {
+			rep.Status = StatusFailing
+		}
+	}
+	return rep
+}
+
+// Handle mounts /healthz, /livez and /readyz on mux.
+func (r *Registry) Handle(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeReport(w, Report{Status: StatusOK})
+	})
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Live(req.Context()))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, r.Ready(req.Context()))
+	})
+}
+
+func writeReport(w http.ResponseWriter, rep Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if rep.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,77 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure/health"
+)
+
+func get(t *testing.T, h http.Handler, path string) (int, health.Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	var rep health.Report
+	if err := json.NewDecoder(w.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, rep
+}
+
+func TestReadiness(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	r := health.NewRegistry(50 * time.Millisecond)
+	r.AddReadinessCheck("keyring", health.CheckerFunc(func(context.Context) error { return nil }))
+	r.AddReadinessCheck("postgres", health.CheckerFunc(func(context.Context) error { return dbErr }))
+	r.AddReadinessCheck("slow", health.CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	mux := http.NewServeMux()
+	r.Handle(mux)
+
+	code, rep := get(t, mux, "/readyz")
+	if code != http.StatusServiceUnavailable || rep.Status != health.StatusFailing {
+		t.Fatalf("want failing, got=%d %+v", code, rep)
+	}
+	if rep.Checks["keyring"] != health.StatusOK || rep.Checks["postgres"] != dbErr.Error() || rep.Checks["slow"] != context.DeadlineExceeded.Error() {
+		t.Fatalf("want the outcome of each check, got %+v", rep.Checks)
+	}
+
+	dbErr = nil
+	r.AddReadinessCheck("slow", health.CheckerFunc(func(context.Context) error { return nil }))
+	if code, rep := get(t, mux, "/readyz"); code != http.StatusOK || rep.Status != health.StatusOK {
+		t.Fatalf("want ready, got=%d %+v", code, rep)
+	}
+
+	r.Shutdown()
+	if code, rep := get(t, mux, "/readyz"); code != http.StatusServiceUnavailable || rep.Status != health.StatusShuttingDown {
+		t.Fatalf("want not ready when shutting down, got=%d %+v", code, rep)
+	}
+	if code, _ := get(t, mux, "/healthz"); code != http.StatusOK {
+		t.Fatalf("want healthy when shutting down, got=%d", code)
+	}
+	if code, _ := get(t, mux, "/livez"); code != http.StatusOK {
+		t.Fatalf("want live when shutting down, got=%d", code)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	r := health.NewRegistry(time.Second)
+	r.AddReadinessCheck("postgres", health.CheckerFunc(func(context.Context) error { return errors.New("down") }))
+	mux := http.NewServeMux()
+	r.Handle(mux)
+
+	if code, _ := get(t, mux, "/livez"); code != http.StatusOK {
+		t.Fatalf("want live despite failing readiness, got=%d", code)
+	}
+	r.AddLivenessCheck("deadlock", health.CheckerFunc(func(context.Context) error { return errors.New("stuck") }))
+	if code, rep := get(t, mux, "/livez"); code != http.StatusServiceUnavailable || rep.Checks["deadlock"] != "stuck" {
+		t.Fatalf("want not live, got=%d %+v", code, rep)
+	}
+}
@@ -0,0 +1,145 @@
+package innsecure
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"google.golang.org/grpc/metadata"
+)
+
+// IdempotencyKeyHeader is the request header, or gRPC metadata key, with
+// which clients identify a request they may retry.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength limits the length of an idempotency key.
+const maxIdempotencyKeyLength = 255
+
+// ErrIdempotencyKeyReused is returned if an idempotency key is used again
+// for a different request.
+const ErrIdempotencyKeyReused ErrorString = "Idempotency key was used for a different request"
+
+// ErrIdempotencyKeyInUse is returned if a request is retried while the
+// original is still in progress.
+const ErrIdempotencyKeyInUse ErrorString = "A request with this idempotency key is in progress"
+
+// IdempotencyStore remembers the requests made with each user's idempotency
+// keys, and their responses.
+type IdempotencyStore interface {
+	// Begin records that the user has started a request with the given key
+	// and fingerprint, to be remembered for ttl. If the key is already in
+	// use, nothing is recorded and the earlier request is returned instead.
+	Begin(ctx context.Context, u *User, key string, fingerprint []byte, ttl time.Duration) (*IdempotentRequest, error)
+	// Complete saves the response to a request started with Begin, which
+	// created the booking with the given ID. The response holds the guest's
+	// personal data, so must be forgotten when the booking's is erased.
+	Complete(ctx context.Context, u *User, key, bookingID string, response []byte) error
+	// Abandon forgets a request started with Begin which failed, so that it
+	// can be retried.
+	Abandon(ctx context.Context, u *User, key string) error
+}
+
+// IdempotentRequest is a request made with an idempotency key.
+type IdempotentRequest struct {
+	// Fingerprint identifies the body of the request.
+	Fingerprint []byte
+	// Response is the JSON encoded response, or nil if the request is still
+	// in progress.
+	Response []byte
+}
+
+type idempotencyKey struct{}
+
+// idempotencyKeyToContext moves the Idempotency-Key header into the context.
+func idempotencyKeyToContext(ctx context.Context, r *http.Request) context.Context {
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		return context.WithValue(ctx, idempotencyKey{}, key)
+	}
+	return ctx
+}
+
+// grpcIdempotencyKeyToContext is the gRPC equivalent of
+// idempotencyKeyToContext.
+func grpcIdempotencyKeyToContext(ctx context.Context, md metadata.MD) context.Context {
+	if v := md.Get(IdempotencyKeyHeader); len(v) > 0 && v[0] != "" {
+		return context.WithValue(ctx, idempotencyKey{}, v[0])
+	}
+	return ctx
+}
+
+// IdempotentCreateBooking returns middleware for the CreateBooking endpoint
+// which, when the request carries an idempotency key, creates the booking
+// only once however often the request is retried within ttl of the first
+// attempt. Retries are answered with the booking originally created, and
+// reusing a key for a different booking is rejected. Keys belong to the
+// authenticated user, so the middleware must be applied after
+// authentication.
+//
+// Only successful responses are remembered: a request which failed may be
+// retried with the same key.
+func IdempotentCreateBooking(store IdempotencyStore, ttl time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key, _ := ctx.Value(idempotencyKey{}).(string)
+			u := UserFromContext(ctx)
+			if key == "" || u == nil {
+				return next(ctx, request)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return nil, withFields(ErrBadRequest, FieldError{
+					Field:   IdempotencyKeyHeader,
+					Message: fmt.Sprintf("must be at most %d characters", maxIdempotencyKeyLength),
+				})
+			}
+
+			body, err := json.Marshal(request)
+			if err != nil {
+				return nil, err
+			}
+			fingerprint := sha256.Sum256(body)
+
+			prev, err := store.Begin(ctx, u, key, fingerprint[:], ttl)
+			if err != nil {
+				return nil, ErrDatabase
+			}
+			if prev != nil {
+				if !bytes.Equal(prev.Fingerprint, fingerprint[:]) {
+					return nil, ErrIdempotencyKeyReused
+				}
+				if prev.Response == nil {
+					return nil, ErrIdempotencyKeyInUse
+				}
+				var b Booking
+				if err := json.Unmarshal(prev.Response, &b); err != nil {
+					return nil, err
+				}
+				return &b, nil
+			}
+
+			response, err := next(ctx, request)
+			if err != nil {
+				store.Abandon(ctx, u, key)
+				return nil, err
+			}
+			saved, err := json.Marshal(response)
+			if err != nil {
+				return nil, err
+			}
+			var bookingID string
+			if b, ok := response.(*Booking); ok {
+				bookingID = b.ID
+			}
+			if err := store.Complete(ctx, u, key, bookingID, saved); err != nil {
+				// The booking has been created, so it is reported even
+				// though a retry would create another.
+				store.Abandon(ctx, u, key)
+			}
+			return response, nil
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package innsecure_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+// memoryIdempotencyStore is an in-memory innsecure.IdempotencyStore which
+// never expires keys.
+type memoryIdempotencyStore struct {
+	mu   sync.Mutex
+	reqs map[string]*innsecure.IdempotentRequest
+}
+
+func (s *memoryIdempotencyStore) Begin(_ context.Context, u *innsecure.User, key string, fingerprint []byte, _ time.Duration) (*innsecure.IdempotentRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.reqs[u.Name+"/"+key]; ok {
+		return r, nil
+	}
+	s.reqs[u.Name+"/"+key] = &innsecure.IdempotentRequest{Fingerprint: fingerprint}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(_ context.Context, u *innsecure.User, key, _ string, response []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reqs[u.Name+"/"+key].Response = response
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Abandon(_ context.Context, u *innsecure.User, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reqs, u.Name+"/"+key)
+	return nil
+}
+
+// asAdmin authenticates every request as an administrator of hotel 123, as
+// the JWT middleware does.
+func asAdmin(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx = innsecure.ContextWithUser(ctx, &innsecure.User{Name: "admin", Admin: true, HotelID: 123})
+		return next(ctx, request)
+	}
+}
+
+func TestIdempotencyKeyReplaysCreatedBooking(t *testing.T) {
+	created := 0
+	service := svc{
+		createBooking: func(_ context.Context, b innsecure.Booking) (*innsecure.Booking, error) {
+			created++
+			b.ID = strings.Repeat("a", created)
+			return &b, nil
+		},
+	}
+	store := &memoryIdempotencyStore{reqs: map[string]*innsecure.IdempotentRequest{}}
+	e := innsecure.MakeServerEndpoints(service, asAdmin, innsecure.WithIdempotency(store, time.Hour))
+	h := innsecure.MakeHTTPHandler(e, log.NewNopLogger())
+
+	post := func(key, name string) *httptest.ResponseRecorder {
+		body := `{"type": "Booking", "hotel_id": 123, "arrive": "2021-08-13", "leave": "2021-08-15", "name": "` + name + `"}`
+		r := jsonRequest("POST", "/hotels/123/bookings", body)
+		if key != "" {
+			r.Header.Set(innsecure.IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	id := func(w *httptest.ResponseRecorder) string {
+		var b innsecure.Booking
+		if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.ID
+	}
+
+	first := post("k1", "Jane Guest")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("want=%d, got=%d", http.StatusCreated, first.Code)
+	}
+	retry := post("k1", "Jane Guest")
+	if retry.Code != http.StatusCreated {
+		t.Fatalf("want=%d, got=%d", http.StatusCreated, retry.Code)
+	}
+	if want, got := id(first), id(retry); want != got {
+		t.Fatalf("want replayed booking %s, got %s", want, got)
+	}
+	if created != 1 {
+		t.Fatalf("want 1 booking created, got %d", created)
+	}
+
+	if w := post("k1", "John Guest"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("want=%d for reused key, got=%d", http.StatusUnprocessableEntity, w.Code)
+	}
+	if w := post("k2", "Jane Guest"); w.Code != http.StatusCreated || created != 2 {
+		t.Fatalf("want a new booking for a new key, got status %d and %d created", w.Code, created)
+	}
+	if w := post("", "Jane Guest"); w.Code != http.StatusCreated || created != 3 {
+		t.Fatalf("want a new booking without a key, got status %d and %d created", w.Code, created)
+	}
+}
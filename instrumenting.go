@@ -0,0 +1,79 @@
+package innsecure
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/form3tech/innsecure/metrics"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Metrics are the service's own metrics: how its endpoints are performing,
+// and what is happening to bookings.
+type Metrics struct {
+	requests        *metrics.Counter
+	errors          *metrics.Counter
+	duration        *metrics.Histogram
+	bookingsCreated *metrics.Counter
+	bookingsErased  *metrics.Counter
+}
+
+// NewMetrics registers the service's metrics with r.
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: r.NewCounter("innsecure_requests_total",
+			"Requests handled, by endpoint.", "method"),
+		errors: r.NewCounter("innsecure_request_errors_total",
+			"Requests which failed, by endpoint and HTTP status code.", "method", "code"),
+		duration: r.NewHistogram("innsecure_request_duration_seconds",
+			"How long endpoints took to respond, by endpoint. Streamed responses are timed until they start.",
+			metrics.DefaultBuckets, "method"),
+		bookingsCreated: r.NewCounter("innsecure_bookings_created_total",
+			"Bookings created, singly or by import, by hotel.", "hotel_id"),
+		bookingsErased: r.NewCounter("innsecure_bookings_erased_total",
+			"Bookings whose guest's personal data was erased on request, by hotel.", "hotel_id"),
+	}
+}
+
+// EndpointMiddleware returns middleware which counts and times the requests
+// to the named endpoint. Errors are counted by the HTTP status code they are
+// reported with, whichever transport the request came by.
+func (m *Metrics) EndpointMiddleware(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				m.requests.Inc(method)
+				if err != nil {
+					m.errors.Inc(method, strconv.Itoa(codeFrom(err)))
+				}
+				m.duration.Observe(time.Since(begin).Seconds(), method)
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// InstrumentEndpoints returns e with each endpoint wrapped in an
+// EndpointMiddleware named after it.
+func (m *Metrics) InstrumentEndpoints(e Endpoints) Endpoints {
+	return e.With(m.EndpointMiddleware)
+}
+
+// bookingsCreatedAt counts n bookings created at a hotel. Like the other
+// business counters, it does nothing if m is nil, so that the service can be
+// used without metrics.
+func (m *Metrics) bookingsCreatedAt(hotelID, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.bookingsCreated.Add(float64(n), strconv.Itoa(hotelID))
+}
+
+// bookingsErasedAt counts n bookings erased at a hotel.
+func (m *Metrics) bookingsErasedAt(hotelID, n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.bookingsErased.Add(float64(n), strconv.Itoa(hotelID))
+}
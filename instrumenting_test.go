@@ -0,0 +1,47 @@
+package innsecure_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/metrics"
+	"github.com/go-kit/kit/log"
+)
+
+func TestMetrics(t *testing.T) {
+	r := repo{
+		insert: func(context.Context, innsecure.Booking) error {
+			return nil
+		},
+		byID: func(context.Context, int, string) (*innsecure.Booking, error) {
+			return nil, nil
+		},
+	}
+	registry := metrics.NewRegistry()
+	m := innsecure.NewMetrics(registry)
+	s := innsecure.NewBookingService(r)
+	s.SetMetrics(m)
+	h := innsecure.MakeHTTPHandler(m.InstrumentEndpoints(innsecure.MakeServerEndpoints(s, asAdmin)), log.NewNopLogger())
+
+	body := `{"type": "Booking", "hotel_id": 123, "arrive": "2021-08-13", "leave": "2021-08-15", "name": "John Guest"}`
+	h.ServeHTTP(httptest.NewRecorder(), jsonRequest("POST", "/hotels/123/bookings", body))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/hotels/123/bookings/a", nil))
+
+	var buf bytes.Buffer
+	registry.WriteTo(&buf)
+	for _, want := range []string{
+		`innsecure_requests_total{method="CreateBooking"} 1`,
+		`innsecure_requests_total{method="GetBookingByID"} 1`,
+		`innsecure_request_errors_total{method="GetBookingByID",code="404"} 1`,
+		`innsecure_request_duration_seconds_count{method="CreateBooking"} 1`,
+		`innsecure_bookings_created_total{hotel_id="123"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("want %s, got:\n%s", want, buf.String())
+		}
+	}
+}
@@ -11,6 +11,14 @@ import (
 
 func NewMiddleware(signingString string) endpoint.Middleware {
 	newClaims := jwt.MapClaimsFactory
+	// Only HMAC signed tokens are accepted, so that a token cannot choose to
+	// be checked some other way, or not at all with "none".
+	keyFunc := func(token *stdjwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*stdjwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrUnexpectedSigningMethod
+		}
+		return []byte(signingString), nil
+	}
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 			tokenString, ok := ctx.Value(jwt.JWTContextKey).(string)
@@ -18,23 +26,60 @@ func NewMiddleware(signingString string) endpoint.Middleware {
 				return nil, jwt.ErrTokenContextMissing
 			}
 
-			token, _, err := new(stdjwt.Parser).ParseUnverified(tokenString, newClaims())
+			token, err := stdjwt.ParseWithClaims(tokenString, newClaims(), keyFunc)
 			if err != nil {
+				return nil, parseError(err)
+			}
+			if !token.Valid {
 				return nil, jwt.ErrTokenInvalid
 			}
 
-			ctx = context.WithValue(ctx, jwt.JWTClaimsContextKey, token.Claims)
-			mc := token.Claims.(stdjwt.MapClaims)
-			hotelID := mc["hotel"].(float64)
+			// Tokens without the claims of a user are rejected, rather than
+			// taken to be of a user with no name or hotel.
+			mc, ok := token.Claims.(stdjwt.MapClaims)
+			if !ok {
+				return nil, jwt.ErrTokenInvalid
+			}
+			hotelID, ok := mc["hotel"].(float64)
+			if !ok {
+				return nil, jwt.ErrTokenInvalid
+			}
+			name, ok := mc["name"].(string)
+			if !ok {
+				return nil, jwt.ErrTokenInvalid
+			}
+			admin, ok := mc["admin"].(bool)
+			if !ok {
+				return nil, jwt.ErrTokenInvalid
+			}
 
-			u := innsecure.User{
-				Name:    mc["name"].(string),
-				Admin:   mc["admin"].(bool),
+			ctx = context.WithValue(ctx, jwt.JWTClaimsContextKey, token.Claims)
+			ctx = innsecure.ContextWithUser(ctx, &innsecure.User{
+				Name:    name,
+				Admin:   admin,
 				HotelID: int(hotelID),
-			}
-			ctx = context.WithValue(ctx, innsecure.UserContextKey, &u)
+			})
 
 			return next(ctx, request)
 		}
 	}
 }
+
+// parseError returns the Go kit error for a token which failed to parse or
+// verify, or jwt.ErrTokenInvalid when there is no more specific one.
+func parseError(err error) error {
+	e, ok := err.(*stdjwt.ValidationError)
+	switch {
+	case !ok:
+		return jwt.ErrTokenInvalid
+	case e.Errors&stdjwt.ValidationErrorMalformed != 0:
+		return jwt.ErrTokenMalformed
+	case e.Errors&stdjwt.ValidationErrorExpired != 0:
+		return jwt.ErrTokenExpired
+	case e.Errors&stdjwt.ValidationErrorNotValidYet != 0:
+		return jwt.ErrTokenNotActive
+	case e.Inner == jwt.ErrUnexpectedSigningMethod:
+		return e.Inner
+	}
+	return jwt.ErrTokenInvalid
+}
@@ -0,0 +1,197 @@
+// Package keyring implements envelope encryption for small values such as
+// guest personal data.
+//
+// Every value is encrypted with its own randomly generated AES-256-GCM data
+// key. The data key is then wrapped (encrypted) with a master key from the
+// keyring, and the wrapped data key is stored alongside the ciphertext with
+// the ID of the master key that wrapped it. Rotating master keys therefore
+// only requires the data keys to be re-wrapped, and a copy of the database on
+// its own reveals nothing.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// keySize is the size in bytes of both master and data keys (AES-256).
+const keySize = 32
+
+// ErrUnknownKey is returned when a value was sealed with a master key that is
+// not present in the keyring.
+var ErrUnknownKey = errors.New("keyring: unknown master key")
+
+// ErrDecrypt is returned when a value cannot be authenticated and decrypted.
+var ErrDecrypt = errors.New("keyring: unable to decrypt value")
+
+// Sealed is an encrypted value together with everything, apart from the
+// master key itself, needed to decrypt it.
+type Sealed struct {
+	// KeyID identifies the master key that wrapped WrappedKey.
+	KeyID string
+	// WrappedKey is the data key, encrypted with the master key.
+	WrappedKey []byte
+	// Ciphertext is the value, encrypted with the data key.
+	Ciphertext []byte
+}
+
+// Keyring holds a set of master keys, one of which is active and used to
+// seal new values. The others are kept so that existing values can still be
+// opened until they have been re-encrypted.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// file is the on-disk representation of a keyring.
+type file struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// Load reads a keyring from a JSON file of the form
+//
+//	{"active": "2021-09", "keys": {"2021-09": "<base64 encoded 32 byte key>"}}
+func Load(path string) (*Keyring, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: failed to read key file: %w", err)
+	}
+	var f file
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("keyring: failed to parse key file: %w", err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		k, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: key %q is not valid base64", id)
+		}
+		keys[id] = k
+	}
+	return New(f.Active, keys)
+}
+
+// New returns a keyring holding the given master keys, using the key with ID
+// active to seal new values.
+func New(active string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("keyring: active key %q is not in the keyring", active)
+	}
+	kr := &Keyring{
+		active: active,
+		keys:   make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, k := range keys {
+		if id == "" {
+			return nil, errors.New("keyring: key IDs must not be empty")
+		}
+		if len(k) != keySize {
+			return nil, fmt.Errorf("keyring: key %q must be %d bytes, got %d", id, keySize, len(k))
+		}
+		aead, err := newAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+	}
+	return kr, nil
+}
+
+// ActiveKeyID returns the ID of the master key used to seal new values.
+func (kr *Keyring) ActiveKeyID() string {
+	return kr.active
+}
+
+// Check seals and opens a value with the active master key, returning an
+// error if the keyring cannot be used.
+func (kr *Keyring) Check() error {
+	s, err := kr.Seal([]byte("check"), nil)
+	if err != nil {
+		return err
+	}
+	_, err = kr.Open(s, nil)
+	return err
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped by the active master
+// key. The additional data is authenticated but not encrypted, and the same
+// value must be supplied to Open; it is used to bind a ciphertext to the row
+// it belongs to so that it cannot be copied to another.
+func (kr *Keyring) Seal(plaintext, additionalData []byte) (Sealed, error) {
+	dk := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dk); err != nil {
+		return Sealed{}, fmt.Errorf("keyring: failed to generate data key: %w", err)
+	}
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return Sealed{}, err
+	}
+	ct, err := seal(aead, plaintext, additionalData)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, err := seal(kr.keys[kr.active], dk, []byte(kr.active))
+	if err != nil {
+		return Sealed{}, err
+	}
+	return Sealed{
+		KeyID:      kr.active,
+		WrappedKey: wrapped,
+		Ciphertext: ct,
+	}, nil
+}
+
+// Open decrypts a value produced by Seal.
+func (kr *Keyring) Open(s Sealed, additionalData []byte) ([]byte, error) {
+	master, ok := kr.keys[s.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	dk, err := open(master, s.WrappedKey, []byte(s.KeyID))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, s.Ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext, prefixing the result with a random nonce.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("keyring: failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ct := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	pt, err := aead.Open(nil, nonce, ct, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
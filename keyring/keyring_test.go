@@ -0,0 +1,92 @@
+package keyring_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/form3tech/innsecure/keyring"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestCanSealAndOpen(t *testing.T) {
+	kr, err := keyring.New("a", map[string][]byte{"a": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := kr.Seal([]byte("Jane Guest"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.KeyID != "a" {
+		t.Fatalf("want=a, got=%s", s.KeyID)
+	}
+	if bytes.Contains(s.Ciphertext, []byte("Jane Guest")) {
+		t.Fatal("ciphertext contains plaintext")
+	}
+	got, err := kr.Open(s, []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Jane Guest" {
+		t.Fatalf("want=Jane Guest, got=%s", got)
+	}
+}
+
+func TestCannotOpenWithDifferentAdditionalData(t *testing.T) {
+	kr, _ := keyring.New("a", map[string][]byte{"a": testKey(1)})
+	s, _ := kr.Seal([]byte("Jane Guest"), []byte("row-1"))
+	if _, err := kr.Open(s, []byte("row-2")); err != keyring.ErrDecrypt {
+		t.Fatalf("want=%s, got=%v", keyring.ErrDecrypt, err)
+	}
+}
+
+func TestCanOpenWithRetiredKeyAfterRotation(t *testing.T) {
+	old, _ := keyring.New("a", map[string][]byte{"a": testKey(1)})
+	s, _ := old.Seal([]byte("Jane Guest"), nil)
+
+	rotated, err := keyring.New("b", map[string][]byte{"a": testKey(1), "b": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Open(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := rotated.Seal([]byte("x"), nil); s.KeyID != "b" {
+		t.Fatalf("want=b, got=%s", s.KeyID)
+	}
+
+	retired, _ := keyring.New("b", map[string][]byte{"b": testKey(2)})
+	if _, err := retired.Open(s, nil); err != keyring.ErrUnknownKey {
+		t.Fatalf("want=%s, got=%v", keyring.ErrUnknownKey, err)
+	}
+}
+
+func TestRejectsInvalidKeys(t *testing.T) {
+	cases := map[string]struct {
+		active string
+		keys   map[string][]byte
+	}{
+		"Missing active key": {active: "b", keys: map[string][]byte{"a": testKey(1)}},
+		"Short key":          {active: "a", keys: map[string][]byte{"a": []byte("short")}},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			if _, err := keyring.New(c.active, c.keys); err == nil {
+				t.Fatal("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	kr, err := keyring.New("a", map[string][]byte{"a": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Check(); err != nil {
+		t.Fatalf("want usable keyring, got %v", err)
+	}
+}
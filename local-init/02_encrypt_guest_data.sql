@@ -0,0 +1,7 @@
+-- Guest names are encrypted by the application before they are stored.
+-- Existing rows keep their plaintext (with a null key_id) until the rekey
+-- command is run.
+ALTER TABLE "Bookings"
+  ALTER COLUMN name TYPE BYTEA USING convert_to(name, 'UTF8'),
+  ADD COLUMN name_key BYTEA,
+  ADD COLUMN key_id TEXT;
@@ -0,0 +1,24 @@
+-- Bookings whose guest data has been erased, either on request or because
+-- the hotel's retention period has passed, keep their dates and hotel for
+-- reporting but have an empty name and no data key.
+ALTER TABLE "Bookings"
+  ADD COLUMN erased_at TIMESTAMPTZ;
+
+-- Hotels without a row here use the service's default retention period.
+CREATE TABLE "HotelRetention"
+(
+  hotelid INTEGER PRIMARY KEY NOT NULL,
+  retention_days INTEGER NOT NULL CHECK (retention_days > 0)
+);
+
+-- ErasureLog records what was erased, when and why. It never contains the
+-- erased data itself.
+CREATE TABLE "ErasureLog"
+(
+  id BIGSERIAL PRIMARY KEY,
+  booking_id UUID NOT NULL,
+  hotelid INTEGER NOT NULL,
+  reason TEXT NOT NULL,
+  requested_by TEXT,
+  erased_at TIMESTAMPTZ NOT NULL
+);
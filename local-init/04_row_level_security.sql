@@ -0,0 +1,48 @@
+-- The service connects as innsecure_app, which does not own the tables and
+-- so is subject to row-level security. Each transaction sets
+-- innsecure.hotel_id to the authenticated user's hotel; without it, no
+-- bookings are visible. The password is for local development only.
+CREATE ROLE innsecure_app LOGIN PASSWORD 'app_password';
+
+GRANT SELECT, INSERT, UPDATE ON "Bookings" TO innsecure_app;
+GRANT SELECT ON "HotelRetention" TO innsecure_app;
+GRANT INSERT ON "ErasureLog" TO innsecure_app;
+GRANT USAGE ON SEQUENCE "ErasureLog_id_seq" TO innsecure_app;
+
+ALTER TABLE "Bookings" ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY hotel_isolation ON "Bookings"
+  USING (hotelid = nullif(current_setting('innsecure.hotel_id', true), '')::INTEGER);
+
+-- The retention job works across all hotels, so runs with the owner's
+-- privileges, but can do nothing other than erase expired guest data.
+CREATE FUNCTION pseudonymise_expired_bookings(today DATE, default_days INTEGER)
+RETURNS INTEGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  erased_count INTEGER;
+BEGIN
+  WITH expired AS (
+    SELECT b.id FROM "Bookings" b
+    LEFT JOIN "HotelRetention" r ON r.hotelid = b.hotelid
+    WHERE b.erased_at IS NULL
+    AND b.leave < to_char(today - coalesce(r.retention_days, default_days), 'YYYY-MM-DD')
+    FOR UPDATE OF b SKIP LOCKED
+  ), erased AS (
+    UPDATE "Bookings" b SET name = '', name_key = NULL, key_id = NULL, erased_at = now()
+    FROM expired e WHERE b.id = e.id
+    RETURNING b.id, b.hotelid, b.erased_at
+  )
+  INSERT INTO "ErasureLog" (booking_id, hotelid, reason, erased_at)
+  SELECT id, hotelid, 'retention', erased_at FROM erased;
+
+  GET DIAGNOSTICS erased_count = ROW_COUNT;
+  RETURN erased_count;
+END;
+$$;
+
+REVOKE ALL ON FUNCTION pseudonymise_expired_bookings(DATE, INTEGER) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION pseudonymise_expired_bookings(DATE, INTEGER) TO innsecure_app;
@@ -0,0 +1,4 @@
+-- Bookings are versioned, starting at 0, and incremented on every change.
+ALTER TABLE "Bookings"
+  ADD COLUMN version INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN cancelled_at TIMESTAMPTZ;
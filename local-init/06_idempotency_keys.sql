@@ -0,0 +1,27 @@
+-- IdempotencyKeys remembers the bookings created by requests with an
+-- Idempotency-Key header, so that retries return the original booking
+-- instead of creating another. The response contains the guest's name, so
+-- is encrypted like the bookings themselves. A null response marks a request
+-- still in progress.
+CREATE TABLE "IdempotencyKeys"
+(
+  hotelid INTEGER NOT NULL,
+  username TEXT NOT NULL,
+  idempotency_key TEXT NOT NULL,
+  fingerprint BYTEA NOT NULL,
+  response BYTEA,
+  response_key BYTEA,
+  key_id TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (hotelid, username, idempotency_key)
+);
+
+CREATE INDEX "IdempotencyKeys_expires_at" ON "IdempotencyKeys" (hotelid, expires_at);
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON "IdempotencyKeys" TO innsecure_app;
+
+ALTER TABLE "IdempotencyKeys" ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY hotel_isolation ON "IdempotencyKeys"
+  USING (hotelid = nullif(current_setting('innsecure.hotel_id', true), '')::INTEGER);
@@ -0,0 +1,30 @@
+-- Erasing a guest's personal data changes the booking, so increments its
+-- version like any other change, invalidating any cached copies identified by
+-- the booking's ETag.
+CREATE OR REPLACE FUNCTION pseudonymise_expired_bookings(today DATE, default_days INTEGER)
+RETURNS INTEGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  erased_count INTEGER;
+BEGIN
+  WITH expired AS (
+    SELECT b.id FROM "Bookings" b
+    LEFT JOIN "HotelRetention" r ON r.hotelid = b.hotelid
+    WHERE b.erased_at IS NULL
+    AND b.leave < to_char(today - coalesce(r.retention_days, default_days), 'YYYY-MM-DD')
+    FOR UPDATE OF b SKIP LOCKED
+  ), erased AS (
+    UPDATE "Bookings" b SET name = '', name_key = NULL, key_id = NULL, erased_at = now(), version = b.version + 1
+    FROM expired e WHERE b.id = e.id
+    RETURNING b.id, b.hotelid, b.erased_at
+  )
+  INSERT INTO "ErasureLog" (booking_id, hotelid, reason, erased_at)
+  SELECT id, hotelid, 'retention', erased_at FROM erased;
+
+  GET DIAGNOSTICS erased_count = ROW_COUNT;
+  RETURN erased_count;
+END;
+$$;
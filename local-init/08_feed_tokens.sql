@@ -0,0 +1,18 @@
+-- FeedTokens grant read-only access to a hotel's bookings as a calendar
+-- feed. Only a hash of each token's secret is stored.
+CREATE TABLE "FeedTokens"
+(
+  id UUID PRIMARY KEY NOT NULL,
+  hotelid INTEGER NOT NULL,
+  token_hash BYTEA NOT NULL UNIQUE,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ
+);
+
+GRANT SELECT, INSERT, UPDATE ON "FeedTokens" TO innsecure_app;
+
+ALTER TABLE "FeedTokens" ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY hotel_isolation ON "FeedTokens"
+  USING (hotelid = nullif(current_setting('innsecure.hotel_id', true), '')::INTEGER);
@@ -0,0 +1,95 @@
+-- BookingEvents records every change to a booking, in order, for clients
+-- following changes as they happen. Events identify the booking but never
+-- contain guest personal data.
+CREATE TABLE "BookingEvents"
+(
+  seq BIGSERIAL PRIMARY KEY,
+  hotelid INTEGER NOT NULL,
+  booking_id UUID NOT NULL,
+  type TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  occurred_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "BookingEvents_hotelid_seq" ON "BookingEvents" (hotelid, seq);
+
+GRANT SELECT, INSERT ON "BookingEvents" TO innsecure_app;
+GRANT USAGE ON SEQUENCE "BookingEvents_seq_seq" TO innsecure_app;
+
+ALTER TABLE "BookingEvents" ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY hotel_isolation ON "BookingEvents"
+  USING (hotelid = nullif(current_setting('innsecure.hotel_id', true), '')::INTEGER);
+
+-- Events of a hotel must become visible in the order of their seq, or a client
+-- could read past one which had yet to commit and never see it. Every
+-- transaction which records events for a hotel holds this lock until it
+-- commits, so that they do.
+CREATE FUNCTION lock_booking_events(hotel INTEGER)
+RETURNS VOID
+LANGUAGE sql
+AS $$
+  SELECT pg_advisory_xact_lock(hashtext('BookingEvents'), hotel);
+$$;
+
+GRANT EXECUTE ON FUNCTION lock_booking_events(INTEGER) TO innsecure_app;
+
+-- Erasing expired guest data updates bookings, so is recorded as well.
+CREATE OR REPLACE FUNCTION pseudonymise_expired_bookings(today DATE, default_days INTEGER)
+RETURNS INTEGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  erased_count INTEGER;
+  hotels INTEGER[];
+BEGIN
+  SELECT array_agg(DISTINCT b.hotelid ORDER BY b.hotelid) INTO hotels FROM "Bookings" b
+  LEFT JOIN "HotelRetention" r ON r.hotelid = b.hotelid
+  WHERE b.erased_at IS NULL
+  AND b.leave < to_char(today - coalesce(r.retention_days, default_days), 'YYYY-MM-DD');
+  PERFORM lock_booking_events(h) FROM unnest(hotels) h;
+
+  WITH expired AS (
+    SELECT b.id FROM "Bookings" b
+    LEFT JOIN "HotelRetention" r ON r.hotelid = b.hotelid
+    WHERE b.erased_at IS NULL
+    AND b.hotelid = ANY(hotels)
+    AND b.leave < to_char(today - coalesce(r.retention_days, default_days), 'YYYY-MM-DD')
+    FOR UPDATE OF b SKIP LOCKED
+  ), erased AS (
+    UPDATE "Bookings" b SET name = '', name_key = NULL, key_id = NULL, erased_at = now(), version = b.version + 1
+    FROM expired e WHERE b.id = e.id
+    RETURNING b.id, b.hotelid, b.erased_at, b.version
+  ), logged AS (
+    INSERT INTO "ErasureLog" (booking_id, hotelid, reason, erased_at)
+    SELECT id, hotelid, 'retention', erased_at FROM erased
+  )
+  INSERT INTO "BookingEvents" (hotelid, booking_id, type, version)
+  SELECT hotelid, id, 'updated', version FROM erased;
+
+  GET DIAGNOSTICS erased_count = ROW_COUNT;
+  RETURN erased_count;
+END;
+$$;
+
+-- Old events are pruned across all hotels, so, like the retention job, this
+-- runs with the owner's privileges but can do nothing else.
+CREATE FUNCTION prune_booking_events(before TIMESTAMPTZ)
+RETURNS INTEGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  pruned_count INTEGER;
+BEGIN
+  DELETE FROM "BookingEvents" WHERE occurred_at < before;
+  GET DIAGNOSTICS pruned_count = ROW_COUNT;
+  RETURN pruned_count;
+END;
+$$;
+
+REVOKE ALL ON FUNCTION prune_booking_events(TIMESTAMPTZ) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION prune_booking_events(TIMESTAMPTZ) TO innsecure_app;
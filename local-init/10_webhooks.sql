@@ -0,0 +1,124 @@
+-- Webhooks subscribe URLs to a hotel's booking events. Their secrets sign
+-- deliveries, so must be recoverable, and are encrypted like guest names.
+CREATE TABLE "Webhooks"
+(
+  id UUID PRIMARY KEY NOT NULL,
+  hotelid INTEGER NOT NULL,
+  url TEXT NOT NULL,
+  events TEXT[] NOT NULL,
+  secret BYTEA NOT NULL,
+  secret_key BYTEA NOT NULL,
+  key_id TEXT NOT NULL,
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+-- WebhookDeliveries queues each event for each webhook subscribed to it, and
+-- records the outcome of the latest attempt at delivering it. Event details
+-- are copied so that deliveries outlive pruned events.
+CREATE TABLE "WebhookDeliveries"
+(
+  id BIGSERIAL PRIMARY KEY,
+  hotelid INTEGER NOT NULL,
+  webhook_id UUID NOT NULL REFERENCES "Webhooks" (id) ON DELETE CASCADE,
+  event_id BIGINT NOT NULL,
+  event_type TEXT NOT NULL,
+  booking_id UUID NOT NULL,
+  version INTEGER NOT NULL,
+  occurred_at TIMESTAMPTZ NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_attempt_at TIMESTAMPTZ,
+  last_status_code INTEGER,
+  last_error TEXT,
+  next_attempt_at TIMESTAMPTZ DEFAULT now(),
+  delivered_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "WebhookDeliveries_due" ON "WebhookDeliveries" (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX "WebhookDeliveries_webhook" ON "WebhookDeliveries" (webhook_id, id);
+
+GRANT SELECT, INSERT, DELETE ON "Webhooks" TO innsecure_app;
+GRANT SELECT, INSERT, UPDATE ON "WebhookDeliveries" TO innsecure_app;
+GRANT USAGE ON SEQUENCE "WebhookDeliveries_id_seq" TO innsecure_app;
+
+ALTER TABLE "Webhooks" ENABLE ROW LEVEL SECURITY;
+ALTER TABLE "WebhookDeliveries" ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY hotel_isolation ON "Webhooks"
+  USING (hotelid = nullif(current_setting('innsecure.hotel_id', true), '')::INTEGER);
+CREATE POLICY hotel_isolation ON "WebhookDeliveries"
+  USING (hotelid = nullif(current_setting('innsecure.hotel_id', true), '')::INTEGER);
+
+-- Every event is queued for delivery in the transaction which records it, so
+-- that none are lost, however the event was recorded.
+CREATE FUNCTION queue_webhook_deliveries()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+AS $$
+BEGIN
+  INSERT INTO "WebhookDeliveries" (hotelid, webhook_id, event_id, event_type, booking_id, version, occurred_at)
+  SELECT NEW.hotelid, w.id, NEW.seq, NEW.type, NEW.booking_id, NEW.version, NEW.occurred_at
+  FROM "Webhooks" w WHERE w.hotelid = NEW.hotelid AND NEW.type = ANY(w.events);
+  RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER queue_webhook_deliveries AFTER INSERT ON "BookingEvents"
+  FOR EACH ROW EXECUTE FUNCTION queue_webhook_deliveries();
+
+-- Due deliveries are claimed across all hotels, so, like the retention job,
+-- this runs with the owner's privileges but can do nothing else. Claimed
+-- deliveries are held for the lease, so that concurrent dispatchers do not
+-- attempt them too.
+CREATE FUNCTION claim_webhook_deliveries(max_count INTEGER, lease INTERVAL)
+RETURNS TABLE (
+  id BIGINT, hotelid INTEGER, webhook_id UUID, event_id BIGINT, event_type TEXT, booking_id UUID,
+  version INTEGER, occurred_at TIMESTAMPTZ, attempts INTEGER, created_at TIMESTAMPTZ,
+  url TEXT, secret BYTEA, secret_key BYTEA, key_id TEXT
+)
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+BEGIN
+  RETURN QUERY
+  WITH due AS (
+    SELECT d.id FROM "WebhookDeliveries" d
+    WHERE d.status = 'pending' AND d.next_attempt_at <= now()
+    ORDER BY d.next_attempt_at
+    LIMIT max_count
+    FOR UPDATE SKIP LOCKED
+  ), claimed AS (
+    UPDATE "WebhookDeliveries" d SET next_attempt_at = now() + lease
+    FROM due WHERE d.id = due.id
+    RETURNING d.*
+  )
+  SELECT c.id, c.hotelid, c.webhook_id, c.event_id, c.event_type, c.booking_id,
+    c.version, c.occurred_at, c.attempts, c.created_at,
+    w.url, w.secret, w.secret_key, w.key_id
+  FROM claimed c JOIN "Webhooks" w ON w.id = c.webhook_id
+  ORDER BY c.id;
+END;
+$$;
+
+REVOKE ALL ON FUNCTION claim_webhook_deliveries(INTEGER, INTERVAL) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION claim_webhook_deliveries(INTEGER, INTERVAL) TO innsecure_app;
+
+-- Finished deliveries are pruned with the events they delivered.
+CREATE OR REPLACE FUNCTION prune_booking_events(before TIMESTAMPTZ)
+RETURNS INTEGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  pruned_count INTEGER;
+BEGIN
+  DELETE FROM "WebhookDeliveries" WHERE status <> 'pending' AND occurred_at < before;
+  DELETE FROM "BookingEvents" WHERE occurred_at < before;
+  GET DIAGNOSTICS pruned_count = ROW_COUNT;
+  RETURN pruned_count;
+END;
+$$;
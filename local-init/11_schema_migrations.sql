@@ -0,0 +1,13 @@
+-- SchemaMigrations records the migrations applied to the database, so that
+-- the service can tell whether the schema it depends on is in place. Every
+-- later migration must record its own number, and the service's
+-- postgres.SchemaVersion be raised to it.
+CREATE TABLE "SchemaMigrations"
+(
+  version INTEGER PRIMARY KEY NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO "SchemaMigrations" (version) SELECT generate_series(1, 11);
+
+GRANT SELECT ON "SchemaMigrations" TO innsecure_app;
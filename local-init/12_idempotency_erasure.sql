@@ -0,0 +1,50 @@
+-- Responses saved for retries of requests with an Idempotency-Key hold the
+-- guest's name, so record the booking they created, and are deleted when its
+-- guest data is erased, whether on request or by the retention job. Responses
+-- saved before this migration have no booking, and expire within a day.
+ALTER TABLE "IdempotencyKeys" ADD COLUMN booking_id UUID;
+
+CREATE INDEX "IdempotencyKeys_booking_id" ON "IdempotencyKeys" (booking_id);
+
+CREATE OR REPLACE FUNCTION pseudonymise_expired_bookings(today DATE, default_days INTEGER)
+RETURNS INTEGER
+LANGUAGE plpgsql
+SECURITY DEFINER
+SET search_path = public
+AS $$
+DECLARE
+  erased_count INTEGER;
+  hotels INTEGER[];
+BEGIN
+  SELECT array_agg(DISTINCT b.hotelid ORDER BY b.hotelid) INTO hotels FROM "Bookings" b
+  LEFT JOIN "HotelRetention" r ON r.hotelid = b.hotelid
+  WHERE b.erased_at IS NULL
+  AND b.leave < to_char(today - coalesce(r.retention_days, default_days), 'YYYY-MM-DD');
+  PERFORM lock_booking_events(h) FROM unnest(hotels) h;
+
+  WITH expired AS (
+    SELECT b.id FROM "Bookings" b
+    LEFT JOIN "HotelRetention" r ON r.hotelid = b.hotelid
+    WHERE b.erased_at IS NULL
+    AND b.hotelid = ANY(hotels)
+    AND b.leave < to_char(today - coalesce(r.retention_days, default_days), 'YYYY-MM-DD')
+    FOR UPDATE OF b SKIP LOCKED
+  ), erased AS (
+    UPDATE "Bookings" b SET name = '', name_key = NULL, key_id = NULL, erased_at = now(), version = b.version + 1
+    FROM expired e WHERE b.id = e.id
+    RETURNING b.id, b.hotelid, b.erased_at, b.version
+  ), logged AS (
+    INSERT INTO "ErasureLog" (booking_id, hotelid, reason, erased_at)
+    SELECT id, hotelid, 'retention', erased_at FROM erased
+  ), forgotten AS (
+    DELETE FROM "IdempotencyKeys" k USING erased e WHERE k.booking_id = e.id
+  )
+  INSERT INTO "BookingEvents" (hotelid, booking_id, type, version)
+  SELECT hotelid, id, 'updated', version FROM erased;
+
+  GET DIAGNOSTICS erased_count = ROW_COUNT;
+  RETURN erased_count;
+END;
+$$;
+
+INSERT INTO "SchemaMigrations" (version) VALUES (12);
@@ -0,0 +1,314 @@
+// Package metrics implements counters, gauges and histograms, and serves them
+// in the Prometheus text exposition format.
+//
+// It covers only what the service needs, without depending on the Prometheus
+// client library: metrics are registered once, at startup, and every series
+// of a metric has the same label names.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets suited to request latencies, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Sample is the value of one series of a metric read at scrape time.
+type Sample struct {
+	// LabelValues are the values of the metric's labels, in order.
+	LabelValues []string
+	Value       float64
+}
+
+// Registry holds metrics and serves them to Prometheus.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// family is a metric, with all of its series.
+type family interface {
+	// write writes the metric's series, without its HELP and TYPE lines.
+	write(w io.Writer)
+	help() string
+	kind() string
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]family{}}
+}
+
+// register adds a metric. Names are fixed at startup, so registering one
+// twice is a programmer error.
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.families[name] = f
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]family, len(names))
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for i, name := range names {
+		f := families[i]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp(f.help()))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.kind())
+		f.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler serves the registry's metrics to Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		r.WriteTo(w)
+	})
+}
+
+// desc describes a metric.
+type desc struct {
+	name   string
+	text   string
+	labels []string
+}
+
+func (d desc) help() string {
+	return d.text
+}
+
+// key identifies a series by its label values, checking that there is one
+// for each label.
+func (d desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got values %v", d.name, d.labels, labelValues))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// series formats the name and labels of a series, with any extra label
+// name and value pairs, such as a histogram's le, appended.
+func (d desc) series(suffix string, labelValues []string, extra ...string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(labelValues[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return d.name + suffix
+	}
+	return d.name + suffix + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a value which only goes up, such as a number of requests.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, text: help, labels: labels},
+		values: map[string]float64{},
+		labels: map[string][]string{},
+	}
+	if len(labels) == 0 {
+		c.Add(0)
+	}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the series with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series with the given label
+// values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: " + c.name + " cannot decrease")
+	}
+	k := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.labels[k]; !ok {
+		c.labels[k] = append([]string(nil), labelValues...)
+	}
+	c.values[k] += v
+}
+
+func (c *Counter) kind() string {
+	return "counter"
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.labels) {
+		fmt.Fprintf(w, "%s %s\n", c.series("", c.labels[k]), formatFloat(c.values[k]))
+	}
+}
+
+// Histogram counts observations, such as request latencies, in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds for its
+// buckets, in increasing order, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, text: help, labels: labels},
+		buckets: buckets,
+		values:  map[string]*histogramSeries{},
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v in the series with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[k]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[k] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) kind() string {
+	return "histogram"
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.values[k]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.series("_bucket", s.labels, "le", formatFloat(le)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.series("_bucket", s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.series("_sum", s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.series("_count", s.labels), s.count)
+	}
+}
+
+// funcFamily is a metric whose series are read at scrape time.
+type funcFamily struct {
+	desc
+	typ string
+	f   func() []Sample
+}
+
+// NewGaugeFunc registers a gauge, a value which goes up and down, whose
+// series are read by calling f at each scrape.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, f func() []Sample) {
+	r.register(name, &funcFamily{desc: desc{name: name, text: help, labels: labels}, typ: "gauge", f: f})
+}
+
+// NewCounterFunc registers a counter whose series are read by calling f at
+// each scrape, for values which are counted elsewhere.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, f func() []Sample) {
+	r.register(name, &funcFamily{desc: desc{name: name, text: help, labels: labels}, typ: "counter", f: f})
+}
+
+func (f *funcFamily) kind() string {
+	return f.typ
+}
+
+func (f *funcFamily) write(w io.Writer) {
+	for _, s := range f.f() {
+		f.key(s.LabelValues)
+		fmt.Fprintf(w, "%s %s\n", f.series("", s.LabelValues), formatFloat(s.Value))
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,68 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure/metrics"
+)
+
+func TestTextFormat(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests handled.", "method", "code")
+	latency := r.NewHistogram("request_duration_seconds", "How long requests took.", []float64{0.1, 1}, "method")
+	r.NewCounter("restarts_total", "Restarts.\nEver.")
+	r.NewGaugeFunc("connections", "Open connections.", []string{"db"}, func() []metrics.Sample {
+		return []metrics.Sample{{LabelValues: []string{"primary"}, Value: 3}}
+	})
+
+	requests.Inc("Get", "200")
+	requests.Add(2, "Get", "200")
+	requests.Inc("Create", `4"0\4`)
+	latency.Observe(0.05, "Get")
+	latency.Observe(0.5, "Get")
+	latency.Observe(5, "Get")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP connections Open connections.
+# TYPE connections gauge
+connections{db="primary"} 3
+# HELP request_duration_seconds How long requests took.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{method="Get",le="0.1"} 1
+request_duration_seconds_bucket{method="Get",le="1"} 2
+request_duration_seconds_bucket{method="Get",le="+Inf"} 3
+request_duration_seconds_sum{method="Get"} 5.55
+request_duration_seconds_count{method="Get"} 3
+# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{method="Create",code="4\"0\\4"} 1
+requests_total{method="Get",code="200"} 3
+# HELP restarts_total Restarts.\nEver.
+# TYPE restarts_total counter
+restarts_total 0
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("requests_total", "Requests handled.").Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("want the text format, got=%d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "requests_total 1\n") {
+		t.Fatalf("want the counter, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,230 @@
+package innsecure
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// OpenAPISpec is the OpenAPI 3 description of the HTTP API.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// spec is the parsed subset of OpenAPISpec used to validate requests.
+var spec = mustParseSpec(OpenAPISpec)
+
+type openAPI struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+// schema is the subset of the OpenAPI schema object used in OpenAPISpec.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Required             []string           `json:"required"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+func mustParseSpec(raw []byte) *openAPI {
+	var s openAPI
+	if err := json.Unmarshal(raw, &s); err != nil {
+		panic(fmt.Sprintf("invalid OpenAPI spec: %s", err))
+	}
+	var compile func(sc *schema)
+	compile = func(sc *schema) {
+		if sc == nil {
+			return
+		}
+		if sc.Pattern != "" {
+			sc.pattern = regexp.MustCompile(sc.Pattern)
+		}
+		for _, p := range sc.Properties {
+			compile(p)
+		}
+		compile(sc.Items)
+	}
+	for _, sc := range s.Components.Schemas {
+		compile(sc)
+	}
+	return &s
+}
+
+// requestSchema returns the schema for the JSON body of the operation with
+// the given method and path template.
+func (s *openAPI) requestSchema(method, path string) *schema {
+	op, ok := s.Paths[path][strings.ToLower(method)]
+	if !ok || op.RequestBody == nil {
+		panic(fmt.Sprintf("no request body for %s %s in OpenAPI spec", method, path))
+	}
+	return s.resolve(op.RequestBody.Content["application/json"].Schema)
+}
+
+func (s *openAPI) resolve(sc *schema) *schema {
+	for sc != nil && sc.Ref != "" {
+		sc = s.Components.Schemas[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+	}
+	return sc
+}
+
+// validate checks v, decoded from JSON, against sc, returning an error for
+// each field which does not match.
+func (s *openAPI) validate(sc *schema, field string, v interface{}) []FieldError {
+	sc = s.resolve(sc)
+	fail := func(format string, a ...interface{}) []FieldError {
+		name := field
+		if name == "" {
+			name = "body"
+		}
+		return []FieldError{{Field: name, Message: fmt.Sprintf(format, a...)}}
+	}
+
+	switch sc.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("must be an object")
+		}
+		var errs []FieldError
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				errs = append(errs, FieldError{Field: join(field, name), Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := sc.Properties[name]
+			if !ok {
+				if sc.AdditionalProperties != nil && !*sc.AdditionalProperties {
+					errs = append(errs, FieldError{Field: join(field, name), Message: "is not allowed"})
+				}
+				continue
+			}
+			errs = append(errs, s.validate(prop, join(field, name), obj[name])...)
+		}
+		return errs
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fail("must be an array")
+		}
+		var errs []FieldError
+		for i, item := range arr {
+			errs = append(errs, s.validate(sc.Items, fmt.Sprintf("%s[%d]", field, i), item)...)
+		}
+		return errs
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fail("must be a string")
+		}
+		if strings.IndexFunc(str, unicode.IsControl) >= 0 {
+			return fail("must not contain control characters")
+		}
+		n := len([]rune(str))
+		if sc.MinLength != nil && n < *sc.MinLength {
+			return fail("must be at least %d characters", *sc.MinLength)
+		}
+		if sc.MaxLength != nil && n > *sc.MaxLength {
+			if *sc.MaxLength == 0 {
+				return fail("must be empty")
+			}
+			return fail("must be at most %d characters", *sc.MaxLength)
+		}
+		if sc.pattern != nil && !sc.pattern.MatchString(str) {
+			return fail("must match %s", sc.Pattern)
+		}
+	case "integer":
+		num, ok := v.(float64)
+		if !ok || num != float64(int64(num)) {
+			return fail("must be an integer")
+		}
+		if sc.Minimum != nil && num < *sc.Minimum {
+			return fail("must be at least %v", *sc.Minimum)
+		}
+		if sc.Maximum != nil && num > *sc.Maximum {
+			return fail("must be at most %v", *sc.Maximum)
+		}
+	}
+	if len(sc.Enum) > 0 {
+		for _, allowed := range sc.Enum {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fail("must be one of %v", sc.Enum)
+	}
+	return nil
+}
+
+func join(parent, field string) string {
+	if parent == "" {
+		return field
+	}
+	return parent + "." + field
+}
+
+// validatingDecoder returns a DecodeRequestFunc which checks the request
+// body against the schema for the given operation in OpenAPISpec before
+// passing the request on to dec. A body which does not match is rejected
+// with invalid, annotated with the fields at fault.
+func validatingDecoder(method, path string, invalid error, dec httptransport.DecodeRequestFunc) httptransport.DecodeRequestFunc {
+	sc := spec.requestSchema(method, path)
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		body, err := readJSON(r)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := unmarshalJSON(body, &v); err != nil {
+			return nil, err
+		}
+		if errs := spec.validate(sc, "", v); len(errs) > 0 {
+			return nil, withFields(invalid, errs...)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return dec(ctx, r)
+	}
+}
+
+// serveOpenAPISpec serves OpenAPISpec.
+func serveOpenAPISpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(OpenAPISpec)
+}
@@ -0,0 +1,125 @@
+package innsecure_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+
+	"github.com/form3tech/innsecure"
+)
+
+func TestOpenAPISpecDescribesEveryRoute(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(innsecure.OpenAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			want = append(want, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(want)
+
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(svc{}, noopMiddleware), log.NewNopLogger())
+	var got, v1 []string
+	err := h.(*mux.Router).Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			// The spec describes paths relative to each version's prefix,
+			// and v1 is also served without one.
+			if strings.HasPrefix(path, "/v1/") {
+				v1 = append(v1, m+" "+strings.TrimPrefix(path, "/v1"))
+				continue
+			}
+			got = append(got, m+" "+path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(v1)
+	if strings.Join(v1, "\n") != strings.Join(got, "\n") {
+		t.Fatalf("v1 routes and unversioned routes differ:\nv1:\n%s\nunversioned:\n%s", strings.Join(v1, "\n"), strings.Join(got, "\n"))
+	}
+
+	if strings.Join(want, "\n") != strings.Join(got, "\n") {
+		t.Fatalf("routes and OpenAPI spec differ:\nspec:\n%s\nroutes:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRejectsInvalidBookingWithFieldErrors(t *testing.T) {
+	service := svc{
+		createBooking: func(_ context.Context, _ innsecure.Booking) (*innsecure.Booking, error) {
+			t.Fatal("create should not have been called, was")
+			return nil, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+
+	body := `{"type": "Booking", "hotel_id": "123", "arrive": "13/08/2021", "leave": "2021-08-15", "colour": "red"}`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, jsonRequest("POST", "/hotels/123/bookings", body))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want=%d, got=%d", http.StatusBadRequest, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/problem+json") {
+		t.Fatalf("want problem+json, got %q", ct)
+	}
+	var got struct {
+		Type   string                 `json:"type"`
+		Code   string                 `json:"code"`
+		Fields []innsecure.FieldError `json:"fields"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != "invalid_booking" || got.Type != innsecure.ProblemTypePrefix+"invalid_booking" {
+		t.Fatalf("want invalid_booking problem, got type=%q code=%q", got.Type, got.Code)
+	}
+	want := map[string]bool{"name": true, "arrive": true, "colour": true, "hotel_id": true}
+	if len(got.Fields) != len(want) {
+		t.Fatalf("want errors for %v, got %+v", want, got.Fields)
+	}
+	for _, f := range got.Fields {
+		if !want[f.Field] {
+			t.Fatalf("unexpected error for %s: %s", f.Field, f.Message)
+		}
+	}
+}
+
+func TestAcceptsValidBooking(t *testing.T) {
+	service := svc{
+		createBooking: func(_ context.Context, b innsecure.Booking) (*innsecure.Booking, error) {
+			return &b, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+
+	body, _ := json.Marshal(validBooking(""))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, jsonRequest("POST", "/hotels/123/bookings", string(body)))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("want=%d, got=%d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,5 @@
+// Package pb contains the protocol buffer definition of the booking service's
+// gRPC API, and the Go code generated from it.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative innsecure.proto
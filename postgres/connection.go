@@ -1,28 +1,143 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/go-kit/kit/log"
 )
 
-const maxAttempts = 5
-const dbName = "innsecure"
-const connectionFormat = "host=%s port=5432 user=%s password=%s dbname=%s sslmode=disable"
+// Config describes a Postgres database and how to pool connections to it.
+type Config struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	// SSLMode is one of disable, require, verify-ca or verify-full.
+	SSLMode string
+	// RootCert is the path to the CA certificate(s) used to verify the
+	// server when SSLMode is verify-ca or verify-full. If empty, the system
+	// roots are used.
+	RootCert string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// ConnectTimeout bounds how long NewConnection waits for the database
+	// to become reachable.
+	ConnectTimeout time.Duration
+}
+
+// DefaultConfig returns a Config with secure defaults and pool sizes suitable
+// for a single small instance.
+func DefaultConfig() Config {
+	return Config{
+		Port:            5432,
+		Database:        "innsecure",
+		SSLMode:         "verify-full",
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 5 * time.Minute,
+		ConnectTimeout:  30 * time.Second,
+	}
+}
+
+// Validate reports whether the configuration is usable.
+func (c Config) Validate() error {
+	switch {
+	case c.Host == "":
+		return fmt.Errorf("database host is required")
+	case c.Port <= 0 || c.Port > 65535:
+		return fmt.Errorf("database port %d is out of range", c.Port)
+	case c.Database == "":
+		return fmt.Errorf("database name is required")
+	case c.MaxOpenConns < 0 || c.MaxIdleConns < 0:
+		return fmt.Errorf("database pool sizes must not be negative")
+	case c.ConnectTimeout <= 0:
+		return fmt.Errorf("database connect timeout must be positive")
+	}
+	switch c.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		return fmt.Errorf("unsupported database sslmode %q", c.SSLMode)
+	}
+	return nil
+}
+
+// DSN returns the connection string for the configuration. It contains the
+// password, so must never be logged; use String for that.
+func (c Config) DSN() string {
+	params := []string{
+		"host=" + quote(c.Host),
+		"port=" + strconv.Itoa(c.Port),
+		"user=" + quote(c.User),
+		"password=" + quote(c.Password),
+		"dbname=" + quote(c.Database),
+		"sslmode=" + quote(c.SSLMode),
+	}
+	if c.RootCert != "" {
+		params = append(params, "sslrootcert="+quote(c.RootCert))
+	}
+	return strings.Join(params, " ")
+}
 
-func NewConnection(host, user, password string) (*sql.DB, error) {
-	psqlconn := fmt.Sprintf(connectionFormat, host, user, password, dbName)
-	fmt.Println(psqlconn)
-	var err error
-	for i := 0; i < maxAttempts; i++ {
-		db, err := sql.Open("postgres", psqlconn)
+// String describes the configuration with the password redacted.
+func (c Config) String() string {
+	return fmt.Sprintf("postgres://%s:REDACTED@%s:%d/%s?sslmode=%s", c.User, c.Host, c.Port, c.Database, c.SSLMode)
+}
+
+// quote quotes a connection string value as described in
+// https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// NewConnection returns a pool of connections to the configured database,
+// waiting with exponential backoff for up to cfg.ConnectTimeout for the
+// database to accept a connection.
+func NewConnection(ctx context.Context, cfg Config, logger log.Logger) (*sql.DB, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", cfg, err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	backoff := 250 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = db.PingContext(ctx)
 		if err == nil {
+			logger.Log("db", cfg.String(), "msg", "connected", "attempt", attempt)
 			return db, nil
 		}
-		time.Sleep(500 * time.Millisecond)
-	}
-	if err != nil {
-		fmt.Printf("failed to connect to database with string: %s\n", psqlconn)
+		logger.Log("db", cfg.String(), "msg", "database not ready", "attempt", attempt, "err", err)
+
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to database %s: %w", cfg, err)
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
 	}
-	return nil, err
 }
@@ -0,0 +1,39 @@
+package postgres_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure/postgres"
+)
+
+func testConfig() postgres.Config {
+	c := postgres.DefaultConfig()
+	c.Host = "db"
+	c.User = "root"
+	c.Password = `pa ss'word\`
+	return c
+}
+
+func TestStringRedactsPassword(t *testing.T) {
+	got := testConfig().String()
+	if strings.Contains(got, "pa ss") {
+		t.Fatalf("password not redacted: %s", got)
+	}
+}
+
+func TestDSNQuotesValues(t *testing.T) {
+	got := testConfig().DSN()
+	want := `password='pa ss\'word\\'`
+	if !strings.Contains(got, want) {
+		t.Fatalf("want %s in %s", want, got)
+	}
+}
+
+func TestValidateRejectsUnknownSSLMode(t *testing.T) {
+	c := testConfig()
+	c.SSLMode = "prefer"
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error, got none")
+	}
+}
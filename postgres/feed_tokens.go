@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/form3tech/innsecure"
+)
+
+// feedTokenColumns lists the columns read by scanFeedToken, in order.
+const feedTokenColumns = `"id", "hotelid", "created_by", "created_at", "revoked_at"`
+
+// InsertFeedToken satisfies Repository.
+func (r *BookingRepo) InsertFeedToken(ctx context.Context, t innsecure.FeedToken, hash []byte) error {
+	return r.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`insert into "FeedTokens" ("id", "hotelid", "token_hash", "created_by", "created_at") values ($1, $2, $3, $4, $5)`,
+			t.ID, t.HotelID, hash, t.CreatedBy, t.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert feed token: %w", err)
+		}
+		return nil
+	})
+}
+
+// FeedTokens satisfies Repository.
+func (r *BookingRepo) FeedTokens(ctx context.Context, hotelID int) ([]innsecure.FeedToken, error) {
+	tokens := []innsecure.FeedToken{}
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `select `+feedTokenColumns+` from "FeedTokens" where "hotelid"=$1 order by "created_at"`, hotelID)
+		if err != nil {
+			return fmt.Errorf("failed to list feed tokens: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			t, err := scanFeedToken(rows)
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, *t)
+		}
+		return rows.Err()
+	})
+	return tokens, err
+}
+
+// RevokeFeedToken satisfies Repository.
+func (r *BookingRepo) RevokeFeedToken(ctx context.Context, hotelID int, ID string) (*innsecure.FeedToken, error) {
+	var t *innsecure.FeedToken
+	err := r.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`update "FeedTokens" set "revoked_at"=now() where "hotelid"=$1 and "id"=$2 and "revoked_at" is null`,
+			hotelID, ID)
+		if err != nil {
+			return fmt.Errorf("failed to revoke feed token: %w", err)
+		}
+		row := tx.QueryRowContext(ctx, `select `+feedTokenColumns+` from "FeedTokens" where "hotelid"=$1 and "id"=$2`, hotelID, ID)
+		t, err = scanFeedToken(row)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	return t, err
+}
+
+// FeedTokenValid satisfies Repository. It always reads from the primary, so
+// that a revoked token stops working immediately.
+func (r *BookingRepo) FeedTokenValid(ctx context.Context, hotelID int, hash []byte) (bool, error) {
+	var valid bool
+	err := inHotel(ctx, r.db, true, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx,
+			`select exists (select 1 from "FeedTokens" where "hotelid"=$1 and "token_hash"=$2 and "revoked_at" is null)`,
+			hotelID, hash).Scan(&valid)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check feed token: %w", err)
+	}
+	return valid, nil
+}
+
+// scanFeedToken reads a feed token selected with feedTokenColumns.
+func scanFeedToken(s scanner) (*innsecure.FeedToken, error) {
+	var (
+		t       innsecure.FeedToken
+		revoked sql.NullTime
+	)
+	if err := s.Scan(&t.ID, &t.HotelID, &t.CreatedBy, &t.CreatedAt, &revoked); err != nil {
+		return nil, err
+	}
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return &t, nil
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/keyring"
+)
+
+// abandonAfter is how long a request may stay in progress before its key is
+// assumed abandoned, for instance because the server stopped part way
+// through, and the request may be retried.
+const abandonAfter = time.Minute
+
+// IdempotencyStore is an innsecure.IdempotencyStore backed by the
+// "IdempotencyKeys" table.
+type IdempotencyStore struct {
+	db   *sql.DB
+	keys *keyring.Keyring
+}
+
+// NewIdempotencyStore returns a store backed by the given DB. Responses
+// contain guest personal data, so are encrypted with keys from the given
+// keyring before being stored.
+func NewIdempotencyStore(db *sql.DB, keys *keyring.Keyring) *IdempotencyStore {
+	return &IdempotencyStore{
+		db:   db,
+		keys: keys,
+	}
+}
+
+// Begin satisfies innsecure.IdempotencyStore. Expired keys of the user's
+// hotel are removed at the same time.
+func (s *IdempotencyStore) Begin(ctx context.Context, u *innsecure.User, key string, fingerprint []byte, ttl time.Duration) (*innsecure.IdempotentRequest, error) {
+	var prev *innsecure.IdempotentRequest
+	err := s.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`delete from "IdempotencyKeys" where "hotelid"=$1 and ("expires_at" < now() or ("response" is null and "created_at" < now() - $2 * interval '1 second'))`,
+			u.HotelID, int(abandonAfter.Seconds()))
+		if err != nil {
+			return fmt.Errorf("failed to remove expired idempotency keys: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx,
+			`insert into "IdempotencyKeys" ("hotelid", "username", "idempotency_key", "fingerprint", "expires_at") values ($1, $2, $3, $4, now() + $5 * interval '1 second') on conflict do nothing`,
+			u.HotelID, u.Name, key, fingerprint, int(ttl.Seconds()))
+		if err != nil {
+			return fmt.Errorf("failed to save idempotency key: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return err
+		}
+
+		var (
+			r           innsecure.IdempotentRequest
+			response    []byte
+			responseKey []byte
+			keyID       sql.NullString
+		)
+		err = tx.QueryRowContext(ctx,
+			`select "fingerprint", "response", "response_key", "key_id" from "IdempotencyKeys" where "hotelid"=$1 and "username"=$2 and "idempotency_key"=$3`,
+			u.HotelID, u.Name, key).Scan(&r.Fingerprint, &response, &responseKey, &keyID)
+		if err != nil {
+			return fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		if keyID.Valid {
+			sealed := keyring.Sealed{KeyID: keyID.String, WrappedKey: responseKey, Ciphertext: response}
+			if r.Response, err = s.keys.Open(sealed, aad(u, key)); err != nil {
+				return fmt.Errorf("failed to decrypt idempotent response: %w", err)
+			}
+		}
+		prev = &r
+		return nil
+	})
+	return prev, err
+}
+
+// Complete satisfies innsecure.IdempotencyStore. The response is saved with
+// the booking's ID, so that erasing the booking deletes it.
+func (s *IdempotencyStore) Complete(ctx context.Context, u *innsecure.User, key, bookingID string, response []byte) error {
+	sealed, err := s.keys.Seal(response, aad(u, key))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt idempotent response: %w", err)
+	}
+	return s.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`update "IdempotencyKeys" set "response"=$4, "response_key"=$5, "key_id"=$6, "booking_id"=$7 where "hotelid"=$1 and "username"=$2 and "idempotency_key"=$3 and "response" is null`,
+			u.HotelID, u.Name, key, sealed.Ciphertext, sealed.WrappedKey, sealed.KeyID, sql.NullString{String: bookingID, Valid: bookingID != ""})
+		if err != nil {
+			return fmt.Errorf("failed to save idempotent response: %w", err)
+		}
+		return nil
+	})
+}
+
+// Abandon satisfies innsecure.IdempotencyStore.
+func (s *IdempotencyStore) Abandon(ctx context.Context, u *innsecure.User, key string) error {
+	return s.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`delete from "IdempotencyKeys" where "hotelid"=$1 and "username"=$2 and "idempotency_key"=$3 and "response" is null`,
+			u.HotelID, u.Name, key)
+		if err != nil {
+			return fmt.Errorf("failed to abandon idempotency key: %w", err)
+		}
+		return nil
+	})
+}
+
+// write runs fn in a hotel transaction (see inHotel) against the primary.
+func (s *IdempotencyStore) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	markWritten(ctx)
+	return inHotel(ctx, s.db, false, fn)
+}
+
+// aad binds an encrypted response to the key it was saved under, so that it
+// cannot be replayed for another user.
+func aad(u *innsecure.User, key string) []byte {
+	return []byte(strconv.Itoa(u.HotelID) + "\x00" + u.Name + "\x00" + key)
+}
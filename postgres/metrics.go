@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"database/sql"
+	"sort"
+
+	"github.com/form3tech/innsecure/metrics"
+)
+
+// InstrumentDBs registers metrics of the connection pools of dbs, keyed by a
+// name such as "primary", with r. They are read from sql.DBStats at each
+// scrape.
+func InstrumentDBs(r *metrics.Registry, dbs map[string]*sql.DB) {
+	names := make([]string, 0, len(dbs))
+	for name := range dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stat := func(f func(sql.DBStats) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			samples := make([]metrics.Sample, len(names))
+			for i, name := range names {
+				samples[i] = metrics.Sample{LabelValues: []string{name}, Value: f(dbs[name].Stats())}
+			}
+			return samples
+		}
+	}
+	labels := []string{"db"}
+
+	r.NewGaugeFunc("innsecure_db_max_open_connections", "Maximum number of open connections to the database.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("innsecure_db_open_connections", "Connections to the database, in use or idle.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("innsecure_db_in_use_connections", "Connections to the database in use.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("innsecure_db_idle_connections", "Idle connections to the database.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("innsecure_db_wait_count_total", "Times a connection to the database was waited for.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("innsecure_db_wait_duration_seconds_total", "Time spent waiting for connections to the database.", labels,
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.NewCounterFunc("innsecure_db_max_idle_closed_total", "Connections closed because too many were idle.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	r.NewCounterFunc("innsecure_db_max_idle_time_closed_total", "Connections closed because they were idle for too long.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	r.NewCounterFunc("innsecure_db_max_lifetime_closed_total", "Connections closed because they reached their maximum lifetime.", labels,
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
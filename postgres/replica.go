@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/lib/pq"
+)
+
+// Replica is a read-only copy of the database which can serve reads that do
+// not need to observe the latest writes. It is only used while it is healthy
+// and its replication lag is within bounds.
+type Replica struct {
+	db      *sql.DB
+	maxLag  time.Duration
+	logger  log.Logger
+	healthy int32
+}
+
+// NewReplica returns a Replica backed by db which is considered unhealthy
+// while it lags the primary by more than maxLag. It starts out healthy; call
+// Monitor to keep its health up to date.
+func NewReplica(db *sql.DB, maxLag time.Duration, logger log.Logger) *Replica {
+	return &Replica{
+		db:      db,
+		maxLag:  maxLag,
+		logger:  logger,
+		healthy: 1,
+	}
+}
+
+// Healthy reports whether reads may be sent to the replica.
+func (rep *Replica) Healthy() bool {
+	return atomic.LoadInt32(&rep.healthy) == 1
+}
+
+// Monitor checks the replica's health every interval until the context is
+// cancelled.
+func (rep *Replica) Monitor(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		rep.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check measures replication lag. A replica which has replayed everything it
+// has received is up to date however long ago the last transaction was.
+func (rep *Replica) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	var lag sql.NullFloat64
+	err := rep.db.QueryRowContext(ctx, `select case
+		when not pg_is_in_recovery() then 0
+		when pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() then 0
+		else extract(epoch from now() - pg_last_xact_replay_timestamp())
+	end`).Scan(&lag)
+	switch {
+	case err != nil:
+		rep.setHealthy(false, "err", err)
+	case !lag.Valid:
+		rep.setHealthy(false, "msg", "replica has not replayed any transactions")
+	case time.Duration(lag.Float64*float64(time.Second)) > rep.maxLag:
+		rep.setHealthy(false, "lag", lag.Float64)
+	default:
+		rep.setHealthy(true)
+	}
+}
+
+// setHealthy records the replica's health, logging changes.
+func (rep *Replica) setHealthy(healthy bool, keyvals ...interface{}) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if atomic.SwapInt32(&rep.healthy, v) != v {
+		rep.logger.Log(append([]interface{}{"replica_healthy", healthy}, keyvals...)...)
+	}
+}
+
+// unavailable reports whether err means that the database could not be
+// reached or is not accepting queries, rather than that a query failed.
+// Errors caused by the request, such as invalid input, say nothing of the
+// replica's health.
+func unavailable(err error) bool {
+	var (
+		pe *pq.Error
+		ne net.Error
+	)
+	switch {
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &ne):
+		return true
+	case errors.As(err, &pe):
+		// Class 08 is connection exceptions, and 57P01 to 57P03 are the
+		// server shutting down or not yet accepting connections.
+		return pe.Code.Class() == "08" || strings.HasPrefix(string(pe.Code), "57P0")
+	}
+	return false
+}
+
+type sessionKey struct{}
+
+// session tracks whether a request has written to the primary.
+type session struct {
+	wrote int32
+}
+
+// WithSession returns a context which tracks writes made through it, so that
+// later reads with the same context are sent to the primary and observe them.
+// It should be called once per request.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+// markWritten records that the request has written to the primary.
+func markWritten(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		atomic.StoreInt32(&s.wrote, 1)
+	}
+}
+
+// hasWritten reports whether the request has written to the primary.
+func hasWritten(ctx context.Context) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	return ok && atomic.LoadInt32(&s.wrote) == 1
+}
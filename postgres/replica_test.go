@@ -0,0 +1,213 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/postgres"
+	"github.com/go-kit/kit/log"
+	"github.com/lib/pq"
+)
+
+// fakeDB is a database/sql connector standing in for a Postgres server. It
+// answers the replication lag query with lag, and every other query with a
+// single value, counting the statements about bookings it runs.
+type fakeDB struct {
+	mu         sync.Mutex
+	lag        interface{}
+	err        error
+	statements int
+}
+
+func (f *fakeDB) set(lag interface{}, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lag, f.err = lag, err
+}
+
+func (f *fakeDB) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.statements
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use a connector") }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+// run returns the result of the statement.
+func (s fakeStmt) run() (driver.Value, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	switch {
+	case strings.Contains(s.query, "pg_is_in_recovery"):
+		return s.db.lag, s.db.err
+	case strings.Contains(s.query, "set_config"):
+		return "", nil
+	}
+	if s.db.err != nil {
+		return nil, s.db.err
+	}
+	s.db.statements++
+	return int64(7), nil
+}
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	_, err := s.run()
+	return driver.RowsAffected(1), err
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	v, err := s.run()
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{value: v}, nil
+}
+
+type fakeRows struct {
+	value driver.Value
+	read  bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"v"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = r.value
+	return nil
+}
+
+// eventually reports whether cond becomes true within a second.
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReplicaHealthFollowsLag(t *testing.T) {
+	fake := &fakeDB{lag: 0.5}
+	replica := postgres.NewReplica(sql.OpenDB(fake), time.Second, log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go replica.Monitor(ctx, time.Millisecond)
+
+	for _, c := range []struct {
+		name    string
+		lag     interface{}
+		err     error
+		healthy bool
+	}{
+		{name: "lagging", lag: 2.5, healthy: false},
+		{name: "caught up", lag: 0.5, healthy: true},
+		{name: "never replayed", lag: nil, healthy: false},
+		{name: "up to date", lag: 0.0, healthy: true},
+		{name: "unreachable", err: errors.New("connection refused"), healthy: false},
+	} {
+		fake.set(c.lag, c.err)
+		if !eventually(func() bool { return replica.Healthy() == c.healthy }) {
+			t.Errorf("%s: want healthy=%v", c.name, c.healthy)
+		}
+	}
+}
+
+func TestReadsGoToHealthyReplica(t *testing.T) {
+	ctx := context.WithValue(context.Background(), innsecure.UserContextKey, &innsecure.User{HotelID: 123})
+	primary, replicaDB := &fakeDB{}, &fakeDB{lag: 0.0}
+	r := postgres.NewRepo(sql.OpenDB(primary), nil)
+	replica := postgres.NewReplica(sql.OpenDB(replicaDB), time.Second, log.NewNopLogger())
+	r.UseReplica(replica)
+
+	read := func(ctx context.Context) {
+		t.Helper()
+		if id, err := r.LastEventID(ctx, 123); err != nil || id != 7 {
+			t.Fatalf("want a read, got %d (%v)", id, err)
+		}
+	}
+	want := func(name string, primaryStatements, replicaStatements int) {
+		t.Helper()
+		if primary.count() != primaryStatements || replicaDB.count() != replicaStatements {
+			t.Fatalf("%s: want %d statements on the primary and %d on the replica, got %d and %d",
+				name, primaryStatements, replicaStatements, primary.count(), replicaDB.count())
+		}
+	}
+
+	read(ctx)
+	want("read", 0, 1)
+
+	// Reads after a write in the same session observe it.
+	session := postgres.WithSession(ctx)
+	read(session)
+	want("read in session", 0, 2)
+	if err := r.InsertFeedToken(session, innsecure.FeedToken{ID: "t", HotelID: 123}, []byte("hash")); err != nil {
+		t.Fatal(err)
+	}
+	want("write", 1, 2)
+	read(session)
+	want("read after write", 2, 2)
+	read(postgres.WithSession(ctx))
+	want("read in another session", 2, 3)
+
+	// A query which fails says nothing of the replica's health.
+	replicaDB.set(0.0, &pq.Error{Code: "22P02", Message: "invalid input syntax for type uuid"})
+	if _, err := r.LastEventID(ctx, 123); err == nil {
+		t.Fatal("want the query's error")
+	}
+	want("failing query", 2, 3)
+	if !replica.Healthy() {
+		t.Fatal("want the replica healthy after a failing query")
+	}
+
+	// A replica which is unavailable is given up on until it is next
+	// checked.
+	replicaDB.set(0.0, driver.ErrBadConn)
+	read(ctx)
+	want("read from unavailable replica", 3, 3)
+	if replica.Healthy() {
+		t.Fatal("want the replica unhealthy after being unavailable")
+	}
+	replicaDB.set(0.0, nil)
+	read(ctx)
+	want("read while unhealthy", 4, 3)
+}
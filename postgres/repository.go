@@ -4,44 +4,77 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/keyring"
+	"github.com/lib/pq"
 )
 
+// bookingColumns lists the columns read by scanBooking, in order.
+const bookingColumns = `"id", "version", "hotelid", "arrive", "leave", "name", "name_key", "key_id", "erased_at", "cancelled_at"`
+
 type BookingRepo struct {
-	db *sql.DB
+	db      *sql.DB
+	replica *Replica
+	keys    *keyring.Keyring
 }
 
-// NewRepo returns a new repository backed by the given DB.
-func NewRepo(db *sql.DB) *BookingRepo {
+// NewRepo returns a new repository backed by the given DB. Guest personal
+// data is encrypted with keys from the given keyring before being stored.
+func NewRepo(db *sql.DB, keys *keyring.Keyring) *BookingRepo {
 	return &BookingRepo{
-		db: db,
+		db:   db,
+		keys: keys,
 	}
 }
 
+// UseReplica sends reads to the given replica while it is healthy, except
+// those made after a write in the same session (see WithSession).
+func (r *BookingRepo) UseReplica(replica *Replica) {
+	r.replica = replica
+}
+
 // Insert satisfies Repository.
 func (r *BookingRepo) Insert(ctx context.Context, b innsecure.Booking) error {
-	stmt := fmt.Sprintf(`insert into "Bookings" ("id", "hotelid", "arrive", "leave", "name") values ('%s', '%d', '%s', '%s', '%s')`, b.ID, b.HotelID, b.Arrive, b.Leave, b.Name)
-	_, err := r.db.Exec(stmt)
-	return err
+	name, err := r.keys.Seal([]byte(b.Name), []byte(b.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt booking: %w", err)
+	}
+	return r.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`insert into "Bookings" ("id", "hotelid", "arrive", "leave", "name", "name_key", "key_id") values ($1, $2, $3, $4, $5, $6, $7)`,
+			b.ID, b.HotelID, b.Arrive, b.Leave, name.Ciphertext, name.WrappedKey, name.KeyID)
+		if err != nil {
+			return err
+		}
+		return recordEvents(ctx, tx, innsecure.EventCreated, b.ID)
+	})
 }
 
 // List returns the full contents of the repository.
 func (r *BookingRepo) List(ctx context.Context, hotelID int) ([]innsecure.Booking, error) {
-	stmt := fmt.Sprintf(`select "id", "hotelid", "arrive", "leave", "name" from "Bookings" WHERE hotelid='%d'`, hotelID)
-	rows, err := r.db.Query(stmt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list bookings: %w", err)
-	}
-	result := []innsecure.Booking{}
-	for rows.Next() {
-		var b innsecure.Booking
-		err = rows.Scan(&b.ID, &b.HotelID, &b.Arrive, &b.Leave, &b.Name)
+	var result []innsecure.Booking
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `select `+bookingColumns+` from "Bookings" where "hotelid"=$1`, hotelID)
 		if err != nil {
-			fmt.Println(err)
-			continue
+			return fmt.Errorf("failed to list bookings: %w", err)
+		}
+		defer rows.Close()
+		result = []innsecure.Booking{}
+		for rows.Next() {
+			b, err := r.scanBooking(rows)
+			if err != nil {
+				return fmt.Errorf("failed to list bookings: %w", err)
+			}
+			result = append(result, *b)
 		}
-		result = append(result, b)
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
 	return result, nil
 }
@@ -49,17 +82,452 @@ func (r *BookingRepo) List(ctx context.Context, hotelID int) ([]innsecure.Bookin
 // ByID returns a single booking by ID.
 // If no booking is found with the given ID, no error is returned.
 func (r *BookingRepo) ByID(ctx context.Context, hotelID int, ID string) (*innsecure.Booking, error) {
-	q := fmt.Sprintf(`select "id", "hotelid", "arrive", "leave", "name" from "Bookings" where "hotelid"='%d' AND "id"='%s'`, hotelID, ID)
-	rows, err := r.db.Query(q)
+	var b *innsecure.Booking
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, `select `+bookingColumns+` from "Bookings" where "hotelid"=$1 and "id"=$2`, hotelID, ID)
+		b, err = r.scanBooking(row)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	return b, err
+}
+
+// Cancel satisfies Repository.
+func (r *BookingRepo) Cancel(ctx context.Context, hotelID int, ID string, version int) (*innsecure.Booking, error) {
+	var b *innsecure.Booking
+	err := r.write(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`update "Bookings" set "cancelled_at"=now(), "version"="version"+1 where "hotelid"=$1 and "id"=$2 and "cancelled_at" is null and ($3 < 0 or "version"=$3)`,
+			hotelID, ID, version)
+		if err != nil {
+			return fmt.Errorf("failed to cancel booking: %w", err)
+		}
+		cancelled, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if cancelled > 0 {
+			if err := recordEvents(ctx, tx, innsecure.EventCancelled, ID); err != nil {
+				return err
+			}
+		}
+		row := tx.QueryRowContext(ctx, `select `+bookingColumns+` from "Bookings" where "hotelid"=$1 and "id"=$2`, hotelID, ID)
+		b, err = r.scanBooking(row)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Nothing was cancelled either because the booking already was, in
+		// which case it is unchanged, or because it has moved on from the
+		// expected version.
+		if cancelled == 0 && version != innsecure.AnyVersion && b.Version != version {
+			return innsecure.ErrPreconditionFailed
+		}
+		return nil
+	})
+	return b, err
+}
+
+// Import satisfies Repository. The bookings are copied into a temporary
+// table with COPY, which is much faster than inserting them one at a time but
+// cannot be used on a table with row-level security, then moved into the
+// bookings table in a single statement, which is subject to it.
+func (r *BookingRepo) Import(ctx context.Context, bookings []innsecure.Booking) error {
+	return r.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `create temporary table "BookingImport" (like "Bookings" including defaults) on commit drop`)
+		if err != nil {
+			return fmt.Errorf("failed to create import table: %w", err)
+		}
+
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("BookingImport", "id", "hotelid", "arrive", "leave", "name", "name_key", "key_id"))
+		if err != nil {
+			return fmt.Errorf("failed to start import: %w", err)
+		}
+		defer stmt.Close()
+		for _, b := range bookings {
+			name, err := r.keys.Seal([]byte(b.Name), []byte(b.ID))
+			if err != nil {
+				return fmt.Errorf("failed to encrypt booking: %w", err)
+			}
+			_, err = stmt.ExecContext(ctx, b.ID, int64(b.HotelID), b.Arrive, b.Leave, name.Ciphertext, name.WrappedKey, name.KeyID)
+			if err != nil {
+				return fmt.Errorf("failed to copy booking: %w", err)
+			}
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return fmt.Errorf("failed to copy bookings: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`insert into "Bookings" ("id", "hotelid", "arrive", "leave", "name", "name_key", "key_id")
+			select "id", "hotelid", "arrive", "leave", "name", "name_key", "key_id" from "BookingImport"`)
+		if err != nil {
+			return fmt.Errorf("failed to import bookings: %w", err)
+		}
+		ids := make([]string, len(bookings))
+		for i, b := range bookings {
+			ids[i] = b.ID
+		}
+		return recordEvents(ctx, tx, innsecure.EventCreated, ids...)
+	})
+}
+
+// exportBatchSize is how many bookings Export reads at a time.
+const exportBatchSize = 500
+
+// Export satisfies Repository. Bookings are read in batches, each in a
+// transaction of its own which ends before the batch is passed to fn, so
+// that a slow caller, such as a client reading an export slowly, does not
+// hold a connection open. Each batch starts after the last booking of the
+// one before, so bookings created or changed during an export may or may
+// not be included, but none are passed twice.
+func (r *BookingRepo) Export(ctx context.Context, hotelID int, fn func(innsecure.Booking) error) error {
+	var last *innsecure.Booking
+	for {
+		var batch []innsecure.Booking
+		err := r.read(ctx, func(tx *sql.Tx) error {
+			// The transaction may be retried on the primary.
+			batch = batch[:0]
+			var (
+				rows *sql.Rows
+				err  error
+			)
+			if last == nil {
+				rows, err = tx.QueryContext(ctx, `select `+bookingColumns+` from "Bookings" where "hotelid"=$1 order by "arrive", "id" limit $2`, hotelID, exportBatchSize)
+			} else {
+				rows, err = tx.QueryContext(ctx, `select `+bookingColumns+` from "Bookings" where "hotelid"=$1 and ("arrive", "id") > ($2, $3) order by "arrive", "id" limit $4`, hotelID, last.Arrive, last.ID, exportBatchSize)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to export bookings: %w", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				b, err := r.scanBooking(rows)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, *b)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			return err
+		}
+		for _, b := range batch {
+			if err := fn(b); err != nil {
+				return err
+			}
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		last = &batch[len(batch)-1]
+	}
+}
+
+// read runs fn in a read-only hotel transaction (see inHotel) against the
+// replica if there is a healthy one and the request has not written to the
+// primary, and otherwise against the primary. A transaction which fails
+// because the replica is unavailable is retried on the primary, and the
+// replica is not used again until it is next checked.
+func (r *BookingRepo) read(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if r.replica != nil && r.replica.Healthy() && !hasWritten(ctx) {
+		err := inHotel(ctx, r.replica.db, true, fn)
+		if err == nil || ctx.Err() != nil || !unavailable(err) {
+			return err
+		}
+		r.replica.setHealthy(false, "err", err)
+	}
+	return inHotel(ctx, r.db, true, fn)
+}
+
+// write runs fn in a hotel transaction (see inHotel) against the primary.
+func (r *BookingRepo) write(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	markWritten(ctx)
+	return inHotel(ctx, r.db, false, fn)
+}
+
+// inHotel runs fn in a transaction in which row-level security limits the
+// visible bookings to those of the authenticated user's hotel. The setting
+// is taken from the user, independently of any query parameters, so that a
+// query which forgets to filter by hotel returns nothing from other hotels.
+// Without an authenticated user no bookings are visible at all.
+func inHotel(ctx context.Context, db *sql.DB, readOnly bool, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer tx.Rollback()
+
+	hotel := ""
+	if u := innsecure.UserFromContext(ctx); u != nil {
+		hotel = strconv.Itoa(u.HotelID)
+	}
+	if _, err := tx.ExecContext(ctx, `select set_config('innsecure.hotel_id', $1, true)`, hotel); err != nil {
+		return fmt.Errorf("failed to set hotel: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reencrypt encrypts the personal data of every booking not already sealed
+// with the keyring's active key under a fresh data key wrapped by the active
+// key. Bookings stored before encryption was introduced are encrypted for the
+// first time. Webhook secrets are re-encrypted too. It is used when rotating
+// master keys, after which the retired key can be removed from the keyring.
+// It returns the number of bookings and webhooks updated.
+//
+// Reencrypt works across all hotels, so must be run as the owner of the
+// bookings table, which is not subject to row-level security.
+func (r *BookingRepo) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	total := 0
+	for {
+		n, err := r.reencryptBatch(ctx, batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n == 0 {
+			break
+		}
 	}
+	n, err := r.reencryptWebhooks(ctx)
+	return total + n, err
+}
+
+func (r *BookingRepo) reencryptBatch(ctx context.Context, batchSize int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`select `+bookingColumns+` from "Bookings" where "erased_at" is null and ("key_id" is null or "key_id"<>$1) limit $2 for update`,
+		r.keys.ActiveKeyID(), batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select bookings to re-encrypt: %w", err)
+	}
+	var batch []innsecure.Booking
 	for rows.Next() {
-		var b innsecure.Booking
-		err = rows.Scan(&b.ID, &b.HotelID, &b.Arrive, &b.Leave, &b.Name)
-		if err == nil {
-			return &b, nil
+		b, err := r.scanBooking(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, *b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, b := range batch {
+		name, err := r.keys.Seal([]byte(b.Name), []byte(b.ID))
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt booking: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`update "Bookings" set "name"=$1, "name_key"=$2, "key_id"=$3 where "id"=$4`,
+			name.Ciphertext, name.WrappedKey, name.KeyID, b.ID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update booking: %w", err)
+		}
+	}
+	return len(batch), tx.Commit()
+}
+
+// EraseGuest satisfies Repository. Guest names are encrypted, so every
+// booking at the hotel is decrypted and compared; this is acceptable for
+// erasure requests, which are rare. Responses saved for retries of the
+// requests which created the bookings are deleted with them.
+func (r *BookingRepo) EraseGuest(ctx context.Context, hotelID int, name, requestedBy string) (int, error) {
+	erased := 0
+	err := r.write(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`select `+bookingColumns+` from "Bookings" where "hotelid"=$1 and "erased_at" is null for update`,
+			hotelID)
+		if err != nil {
+			return fmt.Errorf("failed to select bookings to erase: %w", err)
+		}
+		var ids []string
+		for rows.Next() {
+			b, err := r.scanBooking(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if sameGuest(b.Name, name) {
+				ids = append(ids, b.ID)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		res, err := tx.ExecContext(ctx, `with erased as (
+				update "Bookings" set "name"='', "name_key"=null, "key_id"=null, "erased_at"=now(), "version"="version"+1
+				where "id"=any($1) returning "id", "hotelid", "erased_at"
+			)
+			insert into "ErasureLog" ("booking_id", "hotelid", "reason", "requested_by", "erased_at")
+			select "id", "hotelid", 'request', $2, "erased_at" from erased`,
+			pq.Array(ids), requestedBy)
+		if err != nil {
+			return fmt.Errorf("failed to erase bookings: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		erased = int(n)
+
+		// Responses saved for retries of the requests which created the
+		// bookings hold the guest's name too.
+		if _, err := tx.ExecContext(ctx, `delete from "IdempotencyKeys" where "booking_id"=any($1)`, pq.Array(ids)); err != nil {
+			return fmt.Errorf("failed to erase idempotent responses: %w", err)
+		}
+		return recordEvents(ctx, tx, innsecure.EventUpdated, ids...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return erased, nil
+}
+
+// PseudonymiseExpired satisfies RetentionRepository. It works across all
+// hotels, so is delegated to a database function which runs with the
+// privileges needed to do so (see local-init/04_row_level_security.sql).
+func (r *BookingRepo) PseudonymiseExpired(ctx context.Context, today time.Time, defaultDays int) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `select pseudonymise_expired_bookings($1, $2)`, today.Format("2006-01-02"), defaultDays).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to pseudonymise expired bookings: %w", err)
+	}
+	return n, nil
+}
+
+// PruneEvents satisfies RetentionRepository. Like PseudonymiseExpired, it
+// works across all hotels, so is delegated to a database function (see
+// local-init/09_booking_events.sql).
+func (r *BookingRepo) PruneEvents(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `select prune_booking_events($1)`, before).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune booking events: %w", err)
+	}
+	return n, nil
+}
+
+// Events satisfies Repository.
+func (r *BookingRepo) Events(ctx context.Context, hotelID int, after int64, limit int) ([]innsecure.BookingEvent, error) {
+	var events []innsecure.BookingEvent
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`select "seq", "type", "booking_id", "version", "occurred_at" from "BookingEvents" where "hotelid"=$1 and "seq">$2 order by "seq" limit $3`,
+			hotelID, after, limit)
+		if err != nil {
+			return fmt.Errorf("failed to read booking events: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var e innsecure.BookingEvent
+			if err := rows.Scan(&e.ID, &e.Type, &e.BookingID, &e.Version, &e.OccurredAt); err != nil {
+				return err
+			}
+			events = append(events, e)
 		}
+		return rows.Err()
+	})
+	return events, err
+}
+
+// LastEventID satisfies Repository.
+func (r *BookingRepo) LastEventID(ctx context.Context, hotelID int) (int64, error) {
+	var id int64
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, `select coalesce(max("seq"), 0) from "BookingEvents" where "hotelid"=$1`, hotelID).Scan(&id)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read last booking event: %w", err)
+	}
+	return id, nil
+}
+
+// recordEvents records an event of the given type for each of the bookings,
+// at their current version. It must be called in the transaction which
+// changed them, so that events are recorded if and only if the change is.
+func recordEvents(ctx context.Context, tx *sql.Tx, eventType string, ids ...string) error {
+	// Holds back other changes at the hotel until this one commits, so that
+	// its events cannot be skipped by a reader (see lock_booking_events).
+	_, err := tx.ExecContext(ctx, `select lock_booking_events(nullif(current_setting('innsecure.hotel_id'), '')::integer)`)
+	if err != nil {
+		return fmt.Errorf("failed to record booking events: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`insert into "BookingEvents" ("hotelid", "booking_id", "type", "version")
+		select "hotelid", "id", $1, "version" from "Bookings" where "id"=any($2) order by "id"`,
+		eventType, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to record booking events: %w", err)
+	}
+	return nil
+}
+
+// sameGuest reports whether two guest names refer to the same guest,
+// ignoring case and surrounding whitespace.
+func sameGuest(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBooking reads a booking selected with bookingColumns, decrypting the
+// guest's personal data.
+func (r *BookingRepo) scanBooking(s scanner) (*innsecure.Booking, error) {
+	var (
+		b         innsecure.Booking
+		name      []byte
+		key       []byte
+		keyID     sql.NullString
+		erased    sql.NullTime
+		cancelled sql.NullTime
+	)
+	if err := s.Scan(&b.ID, &b.Version, &b.HotelID, &b.Arrive, &b.Leave, &name, &key, &keyID, &erased, &cancelled); err != nil {
+		return nil, err
+	}
+	b.Type = "Booking"
+	b.Status = innsecure.BookingConfirmed
+	if cancelled.Valid {
+		b.Status = innsecure.BookingCancelled
+	}
+	if erased.Valid {
+		b.Name = innsecure.ErasedGuestName
+		return &b, nil
+	}
+	if !keyID.Valid {
+		// Stored before guest data was encrypted; Reencrypt fixes this.
+		b.Name = string(name)
+		return &b, nil
+	}
+	pt, err := r.keys.Open(keyring.Sealed{KeyID: keyID.String, WrappedKey: key, Ciphertext: name}, []byte(b.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt booking %s: %w", b.ID, err)
 	}
-	return nil, nil
+	b.Name = string(pt)
+	return &b, nil
 }
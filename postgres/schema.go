@@ -0,0 +1,24 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SchemaVersion is the number of the latest migration in local-init which
+// the service depends on.
+const SchemaVersion = 12
+
+// CheckSchema returns an error unless the migrations up to SchemaVersion have
+// been applied to db.
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, `select max(version) from "SchemaMigrations"`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version.Int64 < SchemaVersion {
+		return fmt.Errorf("schema is at version %d, want %d", version.Int64, SchemaVersion)
+	}
+	return nil
+}
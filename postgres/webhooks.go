@@ -0,0 +1,255 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/keyring"
+	"github.com/lib/pq"
+)
+
+// webhookColumns lists the columns read by scanWebhook, in order.
+const webhookColumns = `"id", "hotelid", "url", "events", "created_by", "created_at"`
+
+// deliveryColumns lists the columns read by scanDelivery, in order.
+const deliveryColumns = `"id", "hotelid", "webhook_id", "event_id", "event_type", "booking_id", "version", "occurred_at",
+	"status", "attempts", "last_attempt_at", "last_status_code", "last_error", "next_attempt_at", "delivered_at", "created_at"`
+
+// InsertWebhook satisfies Repository. The webhook's secret is encrypted with
+// the keyring, bound to the webhook's ID.
+func (r *BookingRepo) InsertWebhook(ctx context.Context, w innsecure.Webhook) error {
+	secret, err := r.keys.Seal([]byte(w.Secret), []byte(w.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	return r.write(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`insert into "Webhooks" ("id", "hotelid", "url", "events", "secret", "secret_key", "key_id", "created_by", "created_at") values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			w.ID, w.HotelID, w.URL, pq.Array(w.Events), secret.Ciphertext, secret.WrappedKey, secret.KeyID, w.CreatedBy, w.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert webhook: %w", err)
+		}
+		return nil
+	})
+}
+
+// Webhooks satisfies Repository.
+func (r *BookingRepo) Webhooks(ctx context.Context, hotelID int) ([]innsecure.Webhook, error) {
+	webhooks := []innsecure.Webhook{}
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `select `+webhookColumns+` from "Webhooks" where "hotelid"=$1 order by "created_at"`, hotelID)
+		if err != nil {
+			return fmt.Errorf("failed to list webhooks: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			w, err := scanWebhook(rows)
+			if err != nil {
+				return err
+			}
+			webhooks = append(webhooks, *w)
+		}
+		return rows.Err()
+	})
+	return webhooks, err
+}
+
+// WebhookByID satisfies Repository.
+func (r *BookingRepo) WebhookByID(ctx context.Context, hotelID int, ID string) (*innsecure.Webhook, error) {
+	var w *innsecure.Webhook
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, `select `+webhookColumns+` from "Webhooks" where "hotelid"=$1 and "id"=$2`, hotelID, ID)
+		w, err = scanWebhook(row)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	return w, err
+}
+
+// DeleteWebhook satisfies Repository.
+func (r *BookingRepo) DeleteWebhook(ctx context.Context, hotelID int, ID string) (*innsecure.Webhook, error) {
+	var w *innsecure.Webhook
+	err := r.write(ctx, func(tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, `delete from "Webhooks" where "hotelid"=$1 and "id"=$2 returning `+webhookColumns, hotelID, ID)
+		w, err = scanWebhook(row)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+		return nil
+	})
+	return w, err
+}
+
+// WebhookDeliveries satisfies Repository.
+func (r *BookingRepo) WebhookDeliveries(ctx context.Context, hotelID int, webhookID string, limit int) ([]innsecure.WebhookDelivery, error) {
+	deliveries := []innsecure.WebhookDelivery{}
+	err := r.read(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`select `+deliveryColumns+` from "WebhookDeliveries" where "hotelid"=$1 and "webhook_id"=$2 order by "id" desc limit $3`,
+			hotelID, webhookID, limit)
+		if err != nil {
+			return fmt.Errorf("failed to list webhook deliveries: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			d, err := scanDelivery(rows)
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, *d)
+		}
+		return rows.Err()
+	})
+	return deliveries, err
+}
+
+// ClaimWebhookDeliveries satisfies innsecure.WebhookRepository. Like
+// PseudonymiseExpired, it works across all hotels, so is delegated to a
+// database function (see local-init/10_webhooks.sql).
+func (r *BookingRepo) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]innsecure.DueWebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`select * from claim_webhook_deliveries($1, make_interval(secs => $2))`, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var due []innsecure.DueWebhookDelivery
+	for rows.Next() {
+		var (
+			dd     innsecure.DueWebhookDelivery
+			d      = &dd.Delivery
+			e      = &d.Event
+			sealed keyring.Sealed
+		)
+		err := rows.Scan(&d.ID, &d.HotelID, &d.WebhookID, &e.ID, &e.Type, &e.BookingID,
+			&e.Version, &e.OccurredAt, &d.Attempts, &d.CreatedAt,
+			&dd.URL, &sealed.Ciphertext, &sealed.WrappedKey, &sealed.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+		}
+		d.Status = innsecure.DeliveryPending
+		secret, err := r.keys.Open(sealed, []byte(d.WebhookID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt webhook secret: %w", err)
+		}
+		dd.Secret = string(secret)
+		due = append(due, dd)
+	}
+	return due, rows.Err()
+}
+
+// UpdateWebhookDelivery satisfies innsecure.WebhookRepository.
+func (r *BookingRepo) UpdateWebhookDelivery(ctx context.Context, d innsecure.WebhookDelivery) error {
+	return r.write(ctx, func(tx *sql.Tx) error {
+		var code sql.NullInt64
+		if d.LastStatusCode != 0 {
+			code = sql.NullInt64{Int64: int64(d.LastStatusCode), Valid: true}
+		}
+		_, err := tx.ExecContext(ctx,
+			`update "WebhookDeliveries" set "status"=$3, "attempts"=$4, "last_attempt_at"=$5, "last_status_code"=$6, "last_error"=nullif($7, ''), "next_attempt_at"=$8, "delivered_at"=$9
+			where "hotelid"=$1 and "id"=$2`,
+			d.HotelID, d.ID, d.Status, d.Attempts, d.LastAttemptAt, code, d.LastError, d.NextAttemptAt, d.DeliveredAt)
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+		return nil
+	})
+}
+
+// reencryptWebhooks seals every webhook secret not already sealed with the
+// keyring's active key under a fresh data key, as Reencrypt does for
+// bookings. There are few webhooks, so they are done in one transaction.
+func (r *BookingRepo) reencryptWebhooks(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`select "id", "secret", "secret_key", "key_id" from "Webhooks" where "key_id"<>$1 for update`,
+		r.keys.ActiveKeyID())
+	if err != nil {
+		return 0, fmt.Errorf("failed to select webhooks to re-encrypt: %w", err)
+	}
+	secrets := map[string][]byte{}
+	for rows.Next() {
+		var (
+			id     string
+			sealed keyring.Sealed
+		)
+		if err := rows.Scan(&id, &sealed.Ciphertext, &sealed.WrappedKey, &sealed.KeyID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if secrets[id], err = r.keys.Open(sealed, []byte(id)); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to decrypt webhook secret %s: %w", id, err)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, secret := range secrets {
+		sealed, err := r.keys.Seal(secret, []byte(id))
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`update "Webhooks" set "secret"=$1, "secret_key"=$2, "key_id"=$3 where "id"=$4`,
+			sealed.Ciphertext, sealed.WrappedKey, sealed.KeyID, id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update webhook: %w", err)
+		}
+	}
+	return len(secrets), tx.Commit()
+}
+
+// scanWebhook reads a webhook selected with webhookColumns.
+func scanWebhook(s scanner) (*innsecure.Webhook, error) {
+	var w innsecure.Webhook
+	if err := s.Scan(&w.ID, &w.HotelID, &w.URL, pq.Array(&w.Events), &w.CreatedBy, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// scanDelivery reads a webhook delivery selected with deliveryColumns.
+func scanDelivery(s scanner) (*innsecure.WebhookDelivery, error) {
+	var (
+		d                            innsecure.WebhookDelivery
+		lastAttempt, next, delivered sql.NullTime
+		code                         sql.NullInt64
+		lastError                    sql.NullString
+	)
+	err := s.Scan(&d.ID, &d.HotelID, &d.WebhookID, &d.Event.ID, &d.Event.Type, &d.Event.BookingID, &d.Event.Version, &d.Event.OccurredAt,
+		&d.Status, &d.Attempts, &lastAttempt, &code, &lastError, &next, &delivered, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastAttempt.Valid {
+		d.LastAttemptAt = &lastAttempt.Time
+	}
+	if next.Valid && d.Status == innsecure.DeliveryPending {
+		d.NextAttemptAt = &next.Time
+	}
+	if delivered.Valid {
+		d.DeliveredAt = &delivered.Time
+	}
+	d.LastStatusCode = int(code.Int64)
+	d.LastError = lastError.String
+	return &d, nil
+}
@@ -0,0 +1,77 @@
+package innsecure
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// RetentionRepository removes guest personal data from bookings that are past
+// their retention period.
+type RetentionRepository interface {
+	// PseudonymiseExpired erases the personal data of every booking whose
+	// departure is more than its hotel's retention period before today,
+	// using defaultDays for hotels without their own period, and returns the
+	// number of bookings affected.
+	PseudonymiseExpired(ctx context.Context, today time.Time, defaultDays int) (int, error)
+	// PruneEvents deletes booking events from before the given time, across
+	// all hotels, and returns the number deleted.
+	PruneEvents(ctx context.Context, before time.Time) (int, error)
+}
+
+// RetentionJob periodically enforces the data retention policy.
+type RetentionJob struct {
+	r           RetentionRepository
+	interval    time.Duration
+	defaultDays int
+	keepEvents  time.Duration
+	logger      log.Logger
+}
+
+// NewRetentionJob returns a job which pseudonymises expired bookings every
+// interval, keeping guest data for defaultDays after departure unless the
+// hotel has configured its own retention period. Booking events are kept for
+// keepEvents, which bounds how far back a change feed can resume.
+func NewRetentionJob(r RetentionRepository, interval time.Duration, defaultDays int, keepEvents time.Duration, logger log.Logger) *RetentionJob {
+	return &RetentionJob{
+		r:           r,
+		interval:    interval,
+		defaultDays: defaultDays,
+		keepEvents:  keepEvents,
+		logger:      logger,
+	}
+}
+
+// Run enforces the retention policy immediately, then every interval until
+// the context is cancelled. Failures are logged and retried on the next run.
+func (j *RetentionJob) Run(ctx context.Context) {
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce enforces the retention policy once.
+func (j *RetentionJob) RunOnce(ctx context.Context) {
+	now := time.Now().UTC()
+	n, err := j.r.PseudonymiseExpired(ctx, now, j.defaultDays)
+	if err != nil {
+		j.logger.Log("job", "retention", "err", err)
+		return
+	}
+	j.logger.Log("job", "retention", "pseudonymised", n)
+
+	n, err = j.r.PruneEvents(ctx, now.Add(-j.keepEvents))
+	if err != nil {
+		j.logger.Log("job", "retention", "err", err)
+		return
+	}
+	j.logger.Log("job", "retention", "pruned_events", n)
+}
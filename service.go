@@ -2,6 +2,12 @@ package innsecure
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pborman/uuid"
 )
@@ -27,6 +33,14 @@ const ErrDatabase ErrorString = "Database error"
 // user's permissions set out in the JWT claims
 const ErrUnauthorized ErrorString = "Unauthorised"
 
+// ErrInvalidErasure is returned if an erasure request does not identify a
+// guest.
+const ErrInvalidErasure ErrorString = "Invalid erasure request"
+
+// ErasedGuestName replaces the name of a guest whose personal data has been
+// erased.
+const ErasedGuestName = "[erased]"
+
 // Repository represents a collection of bookings in the database.
 type Repository interface {
 	// Insert creates a new record in the collection, erroring if the ID
@@ -36,6 +50,53 @@ type Repository interface {
 	List(ctx context.Context, hotelID int) ([]Booking, error)
 	// By ID returns a booking by ID.
 	ByID(ctx context.Context, hotelID int, ID string) (*Booking, error)
+	// EraseGuest removes the personal data of the named guest from all of
+	// their bookings at the hotel, recording who asked for it, and returns
+	// the number of bookings affected.
+	EraseGuest(ctx context.Context, hotelID int, name, requestedBy string) (int, error)
+	// Cancel marks a booking as cancelled, incrementing its version, and
+	// returns the updated booking. Cancelling a cancelled booking has no
+	// effect. Unless version is AnyVersion, the booking is only changed if
+	// it is at that version, and ErrPreconditionFailed is returned if not.
+	// If no booking is found with the given ID, no error is returned.
+	Cancel(ctx context.Context, hotelID int, ID string, version int) (*Booking, error)
+	// Import creates many new records at once, erroring if any of their
+	// IDs already exist, in which case none are created.
+	Import(ctx context.Context, in []Booking) error
+	// Export calls fn with each booking of the hotel in turn, in order of
+	// arrival, stopping at the first error.
+	Export(ctx context.Context, hotelID int, fn func(Booking) error) error
+	// InsertFeedToken records a new feed token for the hotel, by the hash of
+	// its secret.
+	InsertFeedToken(ctx context.Context, t FeedToken, hash []byte) error
+	// FeedTokens returns the hotel's feed tokens, without their secrets.
+	FeedTokens(ctx context.Context, hotelID int) ([]FeedToken, error)
+	// RevokeFeedToken revokes a feed token and returns it. Revoking a
+	// revoked token has no effect. If no token is found with the given ID,
+	// no error is returned.
+	RevokeFeedToken(ctx context.Context, hotelID int, ID string) (*FeedToken, error)
+	// FeedTokenValid reports whether the hotel has a feed token, which has
+	// not been revoked, with the given hash.
+	FeedTokenValid(ctx context.Context, hotelID int, hash []byte) (bool, error)
+	// Events returns up to limit of the hotel's events after the event with
+	// the given ID, in order.
+	Events(ctx context.Context, hotelID int, after int64, limit int) ([]BookingEvent, error)
+	// LastEventID returns the ID of the hotel's latest event, or 0 if there
+	// are none.
+	LastEventID(ctx context.Context, hotelID int) (int64, error)
+	// InsertWebhook records a new webhook for the hotel, with its secret.
+	InsertWebhook(ctx context.Context, w Webhook) error
+	// Webhooks returns the hotel's webhooks, without their secrets.
+	Webhooks(ctx context.Context, hotelID int) ([]Webhook, error)
+	// WebhookByID returns a webhook, without its secret. If no webhook is
+	// found with the given ID, no error is returned.
+	WebhookByID(ctx context.Context, hotelID int, ID string) (*Webhook, error)
+	// DeleteWebhook deletes a webhook, with its deliveries, and returns it.
+	// If no webhook is found with the given ID, no error is returned.
+	DeleteWebhook(ctx context.Context, hotelID int, ID string) (*Webhook, error)
+	// WebhookDeliveries returns up to limit of a webhook's most recent
+	// deliveries, newest first.
+	WebhookDeliveries(ctx context.Context, hotelID int, webhookID string, limit int) ([]WebhookDelivery, error)
 }
 
 // Service provides operations on Bookings.
@@ -43,6 +104,19 @@ type Service interface {
 	CreateBooking(ctx context.Context, u *User, b Booking) (*Booking, error)
 	ListBookings(ctx context.Context, u *User) (listing *Listing, err error)
 	GetBookingByID(ctx context.Context, u *User, ID string) (*Booking, error)
+	EraseGuest(ctx context.Context, u *User, r ErasureRequest) (*Erasure, error)
+	CancelBooking(ctx context.Context, u *User, ID string) (*Booking, error)
+	ImportBookings(ctx context.Context, u *User, rows []ImportRow) (*ImportReport, error)
+	ExportBookings(ctx context.Context, u *User, fn func(Booking) error) error
+	CreateFeedToken(ctx context.Context, u *User) (*FeedToken, error)
+	ListFeedTokens(ctx context.Context, u *User) (*FeedTokenListing, error)
+	RevokeFeedToken(ctx context.Context, u *User, ID string) (*FeedToken, error)
+	FeedBookings(ctx context.Context, hotelID int, token string) (*Listing, error)
+	WatchBookings(ctx context.Context, u *User, after int64, fn func(BookingEvent) error) error
+	CreateWebhook(ctx context.Context, u *User, w Webhook) (*Webhook, error)
+	ListWebhooks(ctx context.Context, u *User) (*WebhookListing, error)
+	DeleteWebhook(ctx context.Context, u *User, ID string) (*Webhook, error)
+	ListWebhookDeliveries(ctx context.Context, u *User, webhookID string) (*WebhookDeliveryListing, error)
 }
 
 type User struct {
@@ -54,13 +128,36 @@ type User struct {
 // NewBookingService returns a pointer to a new booking service instance.
 func NewBookingService(r Repository) *BookingService {
 	return &BookingService{
-		r: r,
+		r:            r,
+		pollInterval: time.Second,
 	}
 }
 
 // BookingService satisfies Service.
 type BookingService struct {
-	r Repository
+	r                    Repository
+	pollInterval         time.Duration
+	allowPrivateWebhooks bool
+	metrics              *Metrics
+}
+
+// SetPollInterval sets how often WatchBookings checks for new events. The
+// default is every second.
+func (svc *BookingService) SetPollInterval(d time.Duration) {
+	svc.pollInterval = d
+}
+
+// AllowPrivateWebhooks allows webhooks to be created with plain HTTP URLs and
+// URLs of internal addresses, which are otherwise rejected. It is meant for
+// development, where receivers run locally.
+func (svc *BookingService) AllowPrivateWebhooks(allow bool) {
+	svc.allowPrivateWebhooks = allow
+}
+
+// SetMetrics counts the service's business events, such as bookings created,
+// in m.
+func (svc *BookingService) SetMetrics(m *Metrics) {
+	svc.metrics = m
 }
 
 // ListBookings returns a list of bookings from the database.
@@ -79,21 +176,58 @@ func (svc *BookingService) ListBookings(ctx context.Context, u *User) (*Listing,
 	}, nil
 }
 
-func (svc *BookingService) bookingIsValid(b Booking, allowID bool) bool {
-	if b.Type != "Booking" || b.Version != 0 || b.HotelID == 0 {
-		return false
+// dateLayout is the layout of the dates of a booking.
+const dateLayout = "2006-01-02"
+
+// maxNameLength is the maximum length of a guest's name, in characters.
+const maxNameLength = 200
+
+// bookingErrors returns the fields of b which make it invalid, if any. They
+// are checked whichever transport the booking arrives by, so that dates,
+// which the database compares as text, are always in the same form.
+func (svc *BookingService) bookingErrors(b Booking, allowID bool) []FieldError {
+	var errs []FieldError
+	if b.Type != "Booking" {
+		errs = append(errs, FieldError{Field: "type", Message: "must be Booking"})
+	}
+	if b.Version != 0 {
+		errs = append(errs, FieldError{Field: "version", Message: "must be 0"})
+	}
+	if b.HotelID == 0 {
+		errs = append(errs, FieldError{Field: "hotel_id", Message: "is required"})
+	}
+	arrive, arriveErr := time.Parse(dateLayout, b.Arrive)
+	if arriveErr != nil {
+		errs = append(errs, FieldError{Field: "arrive", Message: "must be a date such as 2021-08-13"})
+	}
+	if leave, err := time.Parse(dateLayout, b.Leave); err != nil {
+		errs = append(errs, FieldError{Field: "leave", Message: "must be a date such as 2021-08-15"})
+	} else if arriveErr == nil && !leave.After(arrive) {
+		errs = append(errs, FieldError{Field: "leave", Message: "must be after arrive"})
+	}
+	switch n := utf8.RuneCountInString(b.Name); {
+	case n == 0:
+		errs = append(errs, FieldError{Field: "name", Message: "is required"})
+	case n > maxNameLength:
+		errs = append(errs, FieldError{Field: "name", Message: fmt.Sprintf("must be at most %d characters", maxNameLength)})
+	}
+	if strings.IndexFunc(b.Name, unicode.IsControl) >= 0 {
+		errs = append(errs, FieldError{Field: "name", Message: "must not contain control characters"})
+	}
+	if b.Status != "" {
+		errs = append(errs, FieldError{Field: "status", Message: "must be empty"})
 	}
 	if !allowID && b.ID != "" {
-		return false
+		errs = append(errs, FieldError{Field: "id", Message: "must be empty"})
 	}
-	return true
+	return errs
 }
 
 // CreateBooking adds a booking to the collection. It returns a a booking
 // object, updated to include its generated ID.
 func (svc *BookingService) CreateBooking(ctx context.Context, u *User, b Booking) (*Booking, error) {
-	if !svc.bookingIsValid(b, false) {
-		return nil, ErrInvalidBooking
+	if errs := svc.bookingErrors(b, false); len(errs) > 0 {
+		return nil, withFields(ErrInvalidBooking, errs...)
 	}
 
 	if u == nil {
@@ -105,11 +239,13 @@ func (svc *BookingService) CreateBooking(ctx context.Context, u *User, b Booking
 	}
 
 	b.ID = uuid.New()
+	b.Status = BookingConfirmed
 
 	err := svc.r.Insert(ctx, b)
 	if err != nil {
 		return nil, err
 	}
+	svc.metrics.bookingsCreatedAt(b.HotelID, 1)
 
 	return &b, nil
 }
@@ -119,6 +255,9 @@ func (svc *BookingService) GetBookingByID(ctx context.Context, u *User, ID strin
 	if u == nil {
 		return nil, ErrUnauthorized
 	}
+	if uuid.Parse(ID) == nil {
+		return nil, ErrNotFound
+	}
 
 	b, err := svc.r.ByID(ctx, u.HotelID, ID)
 	if err != nil {
@@ -132,6 +271,363 @@ func (svc *BookingService) GetBookingByID(ctx context.Context, u *User, ID strin
 	return b, nil
 }
 
+// CancelBooking cancels the booking matching the given ID, if present. If
+// the context carries If-Match entity tags (see WithIfMatch), the booking is
+// only cancelled if it still matches one of them.
+func (svc *BookingService) CancelBooking(ctx context.Context, u *User, ID string) (*Booking, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+	if uuid.Parse(ID) == nil {
+		return nil, ErrNotFound
+	}
+
+	version := AnyVersion
+	if c, ok := ctx.Value(conditionsKey{}).(conditions); ok && c.ifMatch != nil {
+		current, err := svc.r.ByID(ctx, u.HotelID, ID)
+		if err != nil {
+			return nil, ErrDatabase
+		}
+		if current == nil {
+			return nil, ErrNotFound
+		}
+		if !matchETag(c.ifMatch, current.ETag(), false) {
+			return nil, ErrPreconditionFailed
+		}
+		version = current.Version
+	}
+
+	b, err := svc.r.Cancel(ctx, u.HotelID, ID, version)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil, ErrPreconditionFailed
+	}
+	if err != nil {
+		return nil, ErrDatabase
+	}
+
+	if b == nil {
+		return nil, ErrNotFound
+	}
+
+	return b, nil
+}
+
+// ImportBookings creates the bookings of each row which is valid by the same
+// rules as CreateBooking, and reports which were created and why the others
+// were not. Valid bookings are created together, so if any cannot be stored,
+// none are.
+func (svc *BookingService) ImportBookings(ctx context.Context, u *User, rows []ImportRow) (*ImportReport, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+
+	report := &ImportReport{Results: make([]ImportResult, 0, len(rows))}
+	var bookings []Booking
+	for _, row := range rows {
+		errs := row.Errors
+		if len(errs) == 0 {
+			errs = svc.bookingErrors(row.Booking, false)
+			if row.Booking.HotelID != 0 && row.Booking.HotelID != u.HotelID {
+				errs = append(errs, FieldError{Field: "hotel_id", Message: "must be your hotel"})
+			}
+		}
+		if len(errs) > 0 {
+			report.Rejected++
+			report.Results = append(report.Results, ImportResult{Line: row.Line, Status: ImportRejected, Errors: errs})
+			continue
+		}
+
+		b := row.Booking
+		b.ID = uuid.New()
+		b.Status = BookingConfirmed
+		bookings = append(bookings, b)
+		report.Created++
+		report.Results = append(report.Results, ImportResult{Line: row.Line, Status: ImportCreated, ID: b.ID})
+	}
+
+	if len(bookings) > 0 {
+		if err := svc.r.Import(ctx, bookings); err != nil {
+			return nil, ErrDatabase
+		}
+		svc.metrics.bookingsCreatedAt(u.HotelID, len(bookings))
+	}
+	return report, nil
+}
+
+// ExportBookings calls fn with each of the hotel's bookings in turn, without
+// holding them all in memory, stopping at the first error.
+func (svc *BookingService) ExportBookings(ctx context.Context, u *User, fn func(Booking) error) error {
+	if u == nil {
+		return ErrUnauthorized
+	}
+
+	var fnErr error
+	err := svc.r.Export(ctx, u.HotelID, func(b Booking) error {
+		fnErr = fn(b)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return ErrDatabase
+	}
+	return nil
+}
+
+// CreateFeedToken creates a token with which the hotel's bookings may be read
+// as a calendar feed. The token's secret is only returned here; it is stored
+// hashed.
+func (svc *BookingService) CreateFeedToken(ctx context.Context, u *User) (*FeedToken, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+
+	secret, err := newFeedTokenSecret()
+	if err != nil {
+		return nil, err
+	}
+	t := FeedToken{
+		ID:        uuid.New(),
+		HotelID:   u.HotelID,
+		CreatedBy: u.Name,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := svc.r.InsertFeedToken(ctx, t, hashFeedToken(secret)); err != nil {
+		return nil, ErrDatabase
+	}
+
+	t.Token = secret
+	return &t, nil
+}
+
+// ListFeedTokens returns the hotel's feed tokens, without their secrets.
+func (svc *BookingService) ListFeedTokens(ctx context.Context, u *User) (*FeedTokenListing, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+
+	tokens, err := svc.r.FeedTokens(ctx, u.HotelID)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+
+	return &FeedTokenListing{
+		Data: tokens,
+	}, nil
+}
+
+// RevokeFeedToken stops the feed token matching the given ID from being used
+// again.
+func (svc *BookingService) RevokeFeedToken(ctx context.Context, u *User, ID string) (*FeedToken, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+	if uuid.Parse(ID) == nil {
+		return nil, ErrNotFound
+	}
+
+	t, err := svc.r.RevokeFeedToken(ctx, u.HotelID, ID)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+
+	if t == nil {
+		return nil, ErrNotFound
+	}
+
+	return t, nil
+}
+
+// FeedBookings returns the hotel's bookings to the holder of one of its feed
+// tokens. Calendar clients cannot send a bearer token, so the feed token takes
+// the place of an authenticated user.
+func (svc *BookingService) FeedBookings(ctx context.Context, hotelID int, token string) (*Listing, error) {
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+
+	// The repository limits what is visible to the authenticated user's
+	// hotel, which for a feed is the hotel the token is checked against.
+	ctx = ContextWithUser(ctx, &User{Name: "feed", HotelID: hotelID})
+
+	ok, err := svc.r.FeedTokenValid(ctx, hotelID, hashFeedToken(token))
+	if err != nil {
+		return nil, ErrDatabase
+	}
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	list, err := svc.r.List(ctx, hotelID)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+	return &Listing{Data: list}, nil
+}
+
+// eventBatchSize limits the number of events WatchBookings reads at once.
+const eventBatchSize = 100
+
+// WatchBookings calls fn with each change to the hotel's bookings after the
+// event with the given ID, or if it is negative, from now on, until the
+// context is done or fn returns an error.
+func (svc *BookingService) WatchBookings(ctx context.Context, u *User, after int64, fn func(BookingEvent) error) error {
+	if u == nil {
+		return ErrUnauthorized
+	}
+
+	if after < 0 {
+		var err error
+		if after, err = svc.r.LastEventID(ctx, u.HotelID); err != nil {
+			return ErrDatabase
+		}
+	}
+
+	t := time.NewTicker(svc.pollInterval)
+	defer t.Stop()
+	for {
+		events, err := svc.r.Events(ctx, u.HotelID, after, eventBatchSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrDatabase
+		}
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return err
+			}
+			after = e.ID
+		}
+		if len(events) == eventBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// webhookDeliveryLimit limits the number of deliveries ListWebhookDeliveries
+// returns.
+const webhookDeliveryLimit = 100
+
+// CreateWebhook subscribes a URL to changes to the hotel's bookings. The
+// secret with which deliveries are signed is only returned here.
+func (svc *BookingService) CreateWebhook(ctx context.Context, u *User, w Webhook) (*Webhook, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+	if errs := webhookErrors(ctx, w, svc.allowPrivateWebhooks); len(errs) > 0 {
+		return nil, withFields(ErrInvalidWebhook, errs...)
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	w.ID = uuid.New()
+	w.HotelID = u.HotelID
+	w.Secret = secret
+	w.CreatedBy = u.Name
+	w.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if err := svc.r.InsertWebhook(ctx, w); err != nil {
+		return nil, ErrDatabase
+	}
+	return &w, nil
+}
+
+// ListWebhooks returns the hotel's webhooks, without their secrets.
+func (svc *BookingService) ListWebhooks(ctx context.Context, u *User) (*WebhookListing, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+
+	webhooks, err := svc.r.Webhooks(ctx, u.HotelID)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+
+	return &WebhookListing{
+		Data: webhooks,
+	}, nil
+}
+
+// DeleteWebhook stops deliveries to the webhook matching the given ID,
+// including any not yet delivered.
+func (svc *BookingService) DeleteWebhook(ctx context.Context, u *User, ID string) (*Webhook, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+	if uuid.Parse(ID) == nil {
+		return nil, ErrNotFound
+	}
+
+	w, err := svc.r.DeleteWebhook(ctx, u.HotelID, ID)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+
+	if w == nil {
+		return nil, ErrNotFound
+	}
+
+	return w, nil
+}
+
+// ListWebhookDeliveries returns the most recent deliveries to the webhook
+// matching the given ID, with the outcome of their latest attempts.
+func (svc *BookingService) ListWebhookDeliveries(ctx context.Context, u *User, webhookID string) (*WebhookDeliveryListing, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+	if uuid.Parse(webhookID) == nil {
+		return nil, ErrNotFound
+	}
+
+	w, err := svc.r.WebhookByID(ctx, u.HotelID, webhookID)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+	if w == nil {
+		return nil, ErrNotFound
+	}
+
+	deliveries, err := svc.r.WebhookDeliveries(ctx, u.HotelID, webhookID, webhookDeliveryLimit)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+
+	return &WebhookDeliveryListing{
+		Data: deliveries,
+	}, nil
+}
+
+// EraseGuest honours a guest's right to erasure by removing their personal
+// data from all of their bookings at the user's hotel. The bookings themselves
+// are kept so that aggregate reporting is unaffected.
+func (svc *BookingService) EraseGuest(ctx context.Context, u *User, r ErasureRequest) (*Erasure, error) {
+	if u == nil || !u.Admin {
+		return nil, ErrUnauthorized
+	}
+
+	if strings.TrimSpace(r.Name) == "" {
+		return nil, withFields(ErrInvalidErasure, FieldError{Field: "name", Message: "is required"})
+	}
+
+	n, err := svc.r.EraseGuest(ctx, u.HotelID, r.Name, u.Name)
+	if err != nil {
+		return nil, ErrDatabase
+	}
+	svc.metrics.bookingsErasedAt(u.HotelID, n)
+
+	return &Erasure{Erased: n}, nil
+}
+
 // convertDBError converts a repository error to a domain one.
 func convertDBError(err error) error {
 	switch err {
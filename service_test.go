@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/form3tech/innsecure"
@@ -15,6 +16,23 @@ type repo struct {
 	insert func(ctx context.Context, in innsecure.Booking) error
 	list   func(ctx context.Context, hotelID int) ([]innsecure.Booking, error)
 	byID   func(ctx context.Context, hotelID int, ID string) (*innsecure.Booking, error)
+	erase  func(ctx context.Context, hotelID int, name, requestedBy string) (int, error)
+	cancel func(ctx context.Context, hotelID int, ID string, version int) (*innsecure.Booking, error)
+	bulk   func(ctx context.Context, in []innsecure.Booking) error
+	export func(ctx context.Context, hotelID int, fn func(innsecure.Booking) error) error
+
+	insertFeedToken func(ctx context.Context, t innsecure.FeedToken, hash []byte) error
+	feedTokens      func(ctx context.Context, hotelID int) ([]innsecure.FeedToken, error)
+	revokeFeedToken func(ctx context.Context, hotelID int, ID string) (*innsecure.FeedToken, error)
+	feedTokenValid  func(ctx context.Context, hotelID int, hash []byte) (bool, error)
+	events          func(ctx context.Context, hotelID int, after int64, limit int) ([]innsecure.BookingEvent, error)
+	lastEventID     func(ctx context.Context, hotelID int) (int64, error)
+
+	insertWebhook     func(ctx context.Context, w innsecure.Webhook) error
+	webhooks          func(ctx context.Context, hotelID int) ([]innsecure.Webhook, error)
+	webhookByID       func(ctx context.Context, hotelID int, ID string) (*innsecure.Webhook, error)
+	deleteWebhook     func(ctx context.Context, hotelID int, ID string) (*innsecure.Webhook, error)
+	webhookDeliveries func(ctx context.Context, hotelID int, webhookID string, limit int) ([]innsecure.WebhookDelivery, error)
 }
 
 func (r repo) Insert(ctx context.Context, p innsecure.Booking) error {
@@ -29,6 +47,66 @@ func (r repo) ByID(ctx context.Context, hotelID int, ID string) (*innsecure.Book
 	return r.byID(ctx, hotelID, ID)
 }
 
+func (r repo) EraseGuest(ctx context.Context, hotelID int, name, requestedBy string) (int, error) {
+	return r.erase(ctx, hotelID, name, requestedBy)
+}
+
+func (r repo) Cancel(ctx context.Context, hotelID int, ID string, version int) (*innsecure.Booking, error) {
+	return r.cancel(ctx, hotelID, ID, version)
+}
+
+func (r repo) Import(ctx context.Context, in []innsecure.Booking) error {
+	return r.bulk(ctx, in)
+}
+
+func (r repo) Export(ctx context.Context, hotelID int, fn func(innsecure.Booking) error) error {
+	return r.export(ctx, hotelID, fn)
+}
+
+func (r repo) InsertFeedToken(ctx context.Context, t innsecure.FeedToken, hash []byte) error {
+	return r.insertFeedToken(ctx, t, hash)
+}
+
+func (r repo) FeedTokens(ctx context.Context, hotelID int) ([]innsecure.FeedToken, error) {
+	return r.feedTokens(ctx, hotelID)
+}
+
+func (r repo) RevokeFeedToken(ctx context.Context, hotelID int, ID string) (*innsecure.FeedToken, error) {
+	return r.revokeFeedToken(ctx, hotelID, ID)
+}
+
+func (r repo) FeedTokenValid(ctx context.Context, hotelID int, hash []byte) (bool, error) {
+	return r.feedTokenValid(ctx, hotelID, hash)
+}
+
+func (r repo) Events(ctx context.Context, hotelID int, after int64, limit int) ([]innsecure.BookingEvent, error) {
+	return r.events(ctx, hotelID, after, limit)
+}
+
+func (r repo) LastEventID(ctx context.Context, hotelID int) (int64, error) {
+	return r.lastEventID(ctx, hotelID)
+}
+
+func (r repo) InsertWebhook(ctx context.Context, w innsecure.Webhook) error {
+	return r.insertWebhook(ctx, w)
+}
+
+func (r repo) Webhooks(ctx context.Context, hotelID int) ([]innsecure.Webhook, error) {
+	return r.webhooks(ctx, hotelID)
+}
+
+func (r repo) WebhookByID(ctx context.Context, hotelID int, ID string) (*innsecure.Webhook, error) {
+	return r.webhookByID(ctx, hotelID, ID)
+}
+
+func (r repo) DeleteWebhook(ctx context.Context, hotelID int, ID string) (*innsecure.Webhook, error) {
+	return r.deleteWebhook(ctx, hotelID, ID)
+}
+
+func (r repo) WebhookDeliveries(ctx context.Context, hotelID int, webhookID string, limit int) ([]innsecure.WebhookDelivery, error) {
+	return r.webhookDeliveries(ctx, hotelID, webhookID, limit)
+}
+
 func normalUser() *innsecure.User {
 	return &innsecure.User{
 		Name:    "Geoff Capes",
@@ -205,6 +283,28 @@ func TestCanRejectCreateWithInvalidBooking(t *testing.T) {
 			Leave:   "2021-08-15",
 			Name:    "Jane Guest",
 		},
+		"Status set": {
+			Type:    "Booking",
+			Version: 0,
+			HotelID: 123,
+			Arrive:  "2021-08-13",
+			Leave:   "2021-08-15",
+			Name:    "Jane Guest",
+			Status:  "cancelled",
+		},
+	}
+	for name, change := range map[string]func(*innsecure.Booking){
+		"Arrive not a date":     func(b *innsecure.Booking) { b.Arrive = "tomorrow" },
+		"Leave not a date":      func(b *innsecure.Booking) { b.Leave = "2021-8-15" },
+		"Leave before arrive":   func(b *innsecure.Booking) { b.Leave = "2021-08-12" },
+		"Leave on arrival":      func(b *innsecure.Booking) { b.Leave = b.Arrive },
+		"Missing name":          func(b *innsecure.Booking) { b.Name = "" },
+		"Name too long":         func(b *innsecure.Booking) { b.Name = strings.Repeat("é", 201) },
+		"Control chars in name": func(b *innsecure.Booking) { b.Name = "Jane\nGuest" },
+	} {
+		b := validBooking("")
+		change(&b)
+		cases[name] = b
 	}
 	for k, b := range cases {
 		t.Run(k, func(t *testing.T) {
@@ -216,9 +316,13 @@ func TestCanRejectCreateWithInvalidBooking(t *testing.T) {
 			}
 			sut := innsecure.NewBookingService(r)
 			_, err := sut.CreateBooking(context.TODO(), adminUser(), b)
-			if err != innsecure.ErrInvalidBooking {
+			if !errors.Is(err, innsecure.ErrInvalidBooking) {
 				t.Fatalf("want=%s, got=%s", innsecure.ErrInvalidBooking, err)
 			}
+			var e *innsecure.Error
+			if !errors.As(err, &e) || len(e.Fields) == 0 {
+				t.Fatalf("want field errors, got=%+v", err)
+			}
 		})
 	}
 }
@@ -240,15 +344,23 @@ func TestCanHandleRepoFailureWhenCreatingBooking(t *testing.T) {
 
 // Get booking
 
+// IDs of bookings which the repository finds, does not find, or fails to
+// read.
+const (
+	foundID    = "0b6a4f7e-3c1d-4c8a-9f1e-2d7b5a6c8e90"
+	notFoundID = "5d2e8c1a-7b4f-4e3d-8a6c-9f0b1e2d3c4a"
+	dbErrorID  = "9e8d7c6b-5a4f-4e3d-9c2b-1a0f9e8d7c6b"
+)
+
 func TestCanGetBookingsByID(t *testing.T) {
 	r := repo{
 		byID: func(_ context.Context, hotelID int, ID string) (*innsecure.Booking, error) {
 			switch ID {
-			case "found":
+			case foundID:
 				return &innsecure.Booking{HotelID: 123}, nil
-			case "notfound":
+			case notFoundID:
 				return nil, nil
-			case "dberror":
+			case dbErrorID:
 				return nil, errors.New("test error")
 			default:
 				t.Fatal("unexpected ID")
@@ -263,9 +375,10 @@ func TestCanGetBookingsByID(t *testing.T) {
 		want    *innsecure.Booking
 		wantErr error
 	}{
-		{id: "found", want: &innsecure.Booking{HotelID: 123}},
-		{id: "notfound", want: nil, wantErr: innsecure.ErrNotFound},
-		{id: "dberror", want: nil, wantErr: innsecure.ErrDatabase},
+		{id: foundID, want: &innsecure.Booking{HotelID: 123}},
+		{id: notFoundID, want: nil, wantErr: innsecure.ErrNotFound},
+		{id: dbErrorID, want: nil, wantErr: innsecure.ErrDatabase},
+		{id: "not-a-uuid", want: nil, wantErr: innsecure.ErrNotFound},
 	}
 	for _, c := range cases {
 		t.Run(c.id, func(t *testing.T) {
@@ -286,3 +399,148 @@ func TestCanGetBookingsByID(t *testing.T) {
 		})
 	}
 }
+
+// Cancel booking
+
+func TestAdminCanCancelBooking(t *testing.T) {
+	r := repo{
+		cancel: func(_ context.Context, hotelID int, ID string, version int) (*innsecure.Booking, error) {
+			if version != innsecure.AnyVersion {
+				t.Fatalf("want any version, got %d", version)
+			}
+			switch ID {
+			case foundID:
+				return &innsecure.Booking{ID: ID, HotelID: hotelID, Status: innsecure.BookingCancelled, Version: 1}, nil
+			case notFoundID:
+				return nil, nil
+			default:
+				return nil, errors.New("test error")
+			}
+		},
+	}
+	sut := innsecure.NewBookingService(r)
+
+	cases := []struct {
+		id      string
+		want    *innsecure.Booking
+		wantErr error
+	}{
+		{id: foundID, want: &innsecure.Booking{ID: foundID, HotelID: 123, Status: innsecure.BookingCancelled, Version: 1}},
+		{id: notFoundID, wantErr: innsecure.ErrNotFound},
+		{id: dbErrorID, wantErr: innsecure.ErrDatabase},
+	}
+	for _, c := range cases {
+		t.Run(c.id, func(t *testing.T) {
+			got, err := sut.CancelBooking(context.TODO(), adminUser(), c.id)
+			if err != c.wantErr {
+				t.Fatalf("error: want=%+v, got=%+v", c.wantErr, err)
+			}
+			if !reflect.DeepEqual(c.want, got) {
+				t.Fatalf("want=%+v, got=%+v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestCanRejectCancelWithNonAdminUser(t *testing.T) {
+	r := repo{
+		cancel: func(_ context.Context, _ int, _ string, _ int) (*innsecure.Booking, error) {
+			t.Fatal("cancel should not have been called, was")
+			return nil, nil
+		},
+	}
+	sut := innsecure.NewBookingService(r)
+	_, err := sut.CancelBooking(context.TODO(), normalUser(), foundID)
+	if err != innsecure.ErrUnauthorized {
+		t.Fatalf("want=%s, got=%v", innsecure.ErrUnauthorized, err)
+	}
+}
+
+func TestCancelHonoursIfMatch(t *testing.T) {
+	r := repo{
+		byID: func(_ context.Context, hotelID int, ID string) (*innsecure.Booking, error) {
+			if ID == notFoundID {
+				return nil, nil
+			}
+			return &innsecure.Booking{ID: ID, HotelID: hotelID, Version: 2}, nil
+		},
+		cancel: func(_ context.Context, hotelID int, ID string, version int) (*innsecure.Booking, error) {
+			if version != 2 {
+				t.Fatalf("want cancel at version 2, got %d", version)
+			}
+			return &innsecure.Booking{ID: ID, HotelID: hotelID, Status: innsecure.BookingCancelled, Version: 3}, nil
+		},
+	}
+	sut := innsecure.NewBookingService(r)
+
+	cases := []struct {
+		name    string
+		id      string
+		ifMatch []string
+		wantErr error
+	}{
+		{name: "match", id: foundID, ifMatch: []string{`"` + foundID + `.2"`}},
+		{name: "one of several", id: foundID, ifMatch: []string{`"` + foundID + `.1"`, `"` + foundID + `.2"`}},
+		{name: "any", id: foundID, ifMatch: []string{"*"}},
+		{name: "stale", id: foundID, ifMatch: []string{`"` + foundID + `.1"`}, wantErr: innsecure.ErrPreconditionFailed},
+		{name: "weak", id: foundID, ifMatch: []string{`W/"` + foundID + `.2"`}, wantErr: innsecure.ErrPreconditionFailed},
+		{name: "not found", id: notFoundID, ifMatch: []string{"*"}, wantErr: innsecure.ErrNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := innsecure.WithIfMatch(context.TODO(), c.ifMatch)
+			_, err := sut.CancelBooking(ctx, adminUser(), c.id)
+			if err != c.wantErr {
+				t.Fatalf("error: want=%+v, got=%+v", c.wantErr, err)
+			}
+		})
+	}
+}
+
+// Erase guest
+
+func TestAdminCanEraseGuest(t *testing.T) {
+	r := repo{
+		erase: func(_ context.Context, hotelID int, name, requestedBy string) (int, error) {
+			if hotelID != 123 || name != "Jane Guest" || requestedBy != "Geoff Capes" {
+				t.Fatalf("unexpected input: %d, %s, %s", hotelID, name, requestedBy)
+			}
+			return 2, nil
+		},
+	}
+	sut := innsecure.NewBookingService(r)
+	got, err := sut.EraseGuest(context.TODO(), adminUser(), innsecure.ErasureRequest{Name: "Jane Guest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Erased != 2 {
+		t.Fatalf("want=2, got=%d", got.Erased)
+	}
+}
+
+func TestCannotEraseGuest(t *testing.T) {
+	cases := map[string]struct {
+		u       *innsecure.User
+		name    string
+		wantErr error
+	}{
+		"No user":     {u: nil, name: "Jane Guest", wantErr: innsecure.ErrUnauthorized},
+		"Normal user": {u: normalUser(), name: "Jane Guest", wantErr: innsecure.ErrUnauthorized},
+		"No name":     {u: adminUser(), name: " ", wantErr: innsecure.ErrInvalidErasure},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			r := repo{
+				erase: func(_ context.Context, _ int, _, _ string) (int, error) {
+					t.Fatal("erase should not have been called, was")
+					return 0, nil
+				},
+			}
+			sut := innsecure.NewBookingService(r)
+			_, err := sut.EraseGuest(context.TODO(), c.u, innsecure.ErasureRequest{Name: c.name})
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("want=%s, got=%v", c.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// SpanData is a span which has ended, as it is exported.
+type SpanData struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   time.Duration     `json:"duration_ns"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Exporter receives spans as they end. Export is called concurrently, from
+// the goroutine ending the span, so should not block for long.
+type Exporter interface {
+	Export(d SpanData)
+}
+
+// ExporterFunc is an Exporter which calls a function.
+type ExporterFunc func(d SpanData)
+
+// Export satisfies Exporter.
+func (f ExporterFunc) Export(d SpanData) {
+	f(d)
+}
+
+// NewLogExporter returns an Exporter which logs each span as one line.
+func NewLogExporter(logger log.Logger) Exporter {
+	return ExporterFunc(func(d SpanData) {
+		keyvals := []interface{}{
+			"span", d.Name,
+			"trace_id", d.TraceID,
+			"span_id", d.SpanID,
+			"parent_id", d.ParentID,
+			"start", d.Start,
+			"duration", d.Duration,
+		}
+		keys := make([]string, 0, len(d.Attributes))
+		for k := range d.Attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			keyvals = append(keyvals, k, d.Attributes[k])
+		}
+		if d.Error != "" {
+			keyvals = append(keyvals, "err", d.Error)
+		}
+		logger.Log(keyvals...)
+	})
+}
+
+// NewJSONExporter returns an Exporter which writes each span to w as a line
+// of JSON, such as to a file collected by a tracing agent.
+func NewJSONExporter(w io.Writer) Exporter {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return ExporterFunc(func(d SpanData) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(d)
+	})
+}
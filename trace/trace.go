@@ -0,0 +1,300 @@
+// Package trace propagates W3C Trace Context (https://www.w3.org/TR/trace-context/)
+// and records lightweight spans of the work done for each request.
+//
+// A Tracer starts the span of a request, continuing the trace of the caller
+// if the request carries a traceparent header. Code handling the request
+// starts child spans with Start, which does nothing outside of a traced
+// request, so that it can be called unconditionally. Spans are handed to an
+// Exporter when they end.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers carrying the trace context.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// maxTracestateLength is the length beyond which a tracestate is dropped
+// rather than propagated.
+const maxTracestateLength = 512
+
+// flagSampled is the trace flag recording that the caller may be recording
+// the trace.
+const flagSampled = 0x01
+
+// ErrInvalidTraceparent is returned when a traceparent header is malformed.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace: a request and everything done for it, across
+// services.
+type TraceID [16]byte
+
+// String returns the ID in lowercase hex.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the ID in lowercase hex.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span which is propagated to the services it
+// calls.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	// State is the vendor-specific tracestate, propagated unchanged.
+	State string
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Sampled reports whether the trace is being recorded.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// Traceparent returns sc formatted as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceparent parses a traceparent header. Versions later than 00 are
+// parsed as far as version 00 defines them, as the specification requires.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version, ok := decodeHex(s[:2], 1)
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return sc, ErrInvalidTraceparent
+	}
+	traceID, ok := decodeHex(s[3:35], 16)
+	if !ok {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, ok := decodeHex(s[36:52], 8)
+	if !ok {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, ok := decodeHex(s[53:55], 1)
+	if !ok {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes n bytes of lowercase hex.
+func decodeHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// Extract returns the trace context of an incoming request, or an invalid
+// SpanContext if it has none, or a malformed one.
+func Extract(h http.Header) SpanContext {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}
+	}
+	state := strings.Join(h.Values(TracestateHeader), ",")
+	if len(state) <= maxTracestateLength {
+		sc.State = state
+	}
+	return sc
+}
+
+// Inject sets the trace context headers of an outgoing request to continue
+// the trace of the span in ctx, if there is one.
+func Inject(ctx context.Context, h http.Header) {
+	sc := FromContext(ctx).Context()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.State != "" {
+		h.Set(TracestateHeader, sc.State)
+	} else {
+		h.Del(TracestateHeader)
+	}
+}
+
+// Tracer starts the spans of requests, and exports them when they end.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer exporting spans to e.
+func NewTracer(e Exporter) *Tracer {
+	return &Tracer{exporter: e}
+}
+
+// Start starts a span continuing the trace of parent, or starting a new
+// trace if parent is invalid, and returns it with a context holding it.
+// New traces are always sampled; continued ones are sampled if the caller's
+// are. A nil Tracer starts no span, returning ctx and a nil Span.
+func (t *Tracer) Start(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+	}
+	if parent.IsValid() {
+		s.sc = parent
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Flags = flagSampled
+	}
+	rand.Read(s.sc.SpanID[:])
+	return ContextWithSpan(ctx, s), s
+}
+
+// Start starts a child of the span in ctx, and returns it with a context
+// holding it. Outside of a span it starts none, returning ctx and a nil Span,
+// whose methods do nothing.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, parent.sc)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns ctx holding s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span held by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Span is a unit of work done for a request. Its methods are safe for
+// concurrent use, and do nothing on a nil Span.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	name  string
+	attrs map[string]string
+	ended bool
+}
+
+// Context returns the span's SpanContext, which is invalid for a nil Span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames the span, such as once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute records an attribute of the span. Attributes are exported,
+// so must not hold credentials or personal data.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = map[string]string{}
+	}
+	s.attrs[key] = value
+}
+
+// End ends the span, recording err if it failed, and exports it if the
+// trace is sampled. Only the first call has any effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	d := SpanData{
+		Name:       s.name,
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Start:      s.start,
+		Duration:   time.Since(s.start),
+		Attributes: s.attrs,
+	}
+	s.mu.Unlock()
+
+	if !s.sc.Sampled() || s.tracer.exporter == nil {
+		return
+	}
+	if s.parent.IsValid() {
+		d.ParentID = s.parent.String()
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	s.tracer.exporter.Export(d)
+}
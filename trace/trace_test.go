@@ -0,0 +1,125 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure/trace"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false},
+		{"", false},
+	} {
+		sc, err := trace.ParseTraceparent(tc.header)
+		if valid := err == nil; valid != tc.valid {
+			t.Errorf("%q: want valid=%v, got %v", tc.header, tc.valid, err)
+			continue
+		}
+		if tc.valid && !strings.HasPrefix(tc.header, "01") && sc.Traceparent() != tc.header {
+			t.Errorf("want %q formatted unchanged, got %q", tc.header, sc.Traceparent())
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	var spans []trace.SpanData
+	tracer := trace.NewTracer(trace.ExporterFunc(func(d trace.SpanData) {
+		spans = append(spans, d)
+	}))
+
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	in.Add("tracestate", "congo=t61rcWkgMzE")
+	in.Add("tracestate", "rojo=00f067aa0ba902b7")
+	ctx, root := tracer.Start(context.Background(), "request", trace.Extract(in))
+	ctx, child := trace.Start(ctx, "child")
+	child.SetAttribute("hotel_id", "123")
+
+	out := http.Header{}
+	trace.Inject(ctx, out)
+	sc, err := trace.ParseTraceparent(out.Get("traceparent"))
+	if err != nil || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID != child.Context().SpanID {
+		t.Fatalf("want the trace continued from the child span, got %q (%v)", out.Get("traceparent"), err)
+	}
+	if got := out.Get("tracestate"); got != "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7" {
+		t.Fatalf("want the tracestate propagated, got %q", got)
+	}
+
+	child.End(errors.New("boom"))
+	child.End(nil)
+	root.End(nil)
+	if len(spans) != 2 {
+		t.Fatalf("want each span exported once, got %+v", spans)
+	}
+	if spans[0].Name != "child" || spans[0].ParentID != root.Context().SpanID.String() || spans[0].Error != "boom" || spans[0].Attributes["hotel_id"] != "123" {
+		t.Fatalf("want the child span with its parent, error and attributes, got %+v", spans[0])
+	}
+	if spans[1].ParentID != "00f067aa0ba902b7" {
+		t.Fatalf("want the root span's parent to be the caller's span, got %+v", spans[1])
+	}
+}
+
+func TestUnsampledTracesAreNotExported(t *testing.T) {
+	var exported int
+	tracer := trace.NewTracer(trace.ExporterFunc(func(trace.SpanData) { exported++ }))
+
+	in := http.Header{}
+	in.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, root := tracer.Start(context.Background(), "request", trace.Extract(in))
+	_, child := trace.Start(ctx, "child")
+	child.End(nil)
+	root.End(nil)
+	if exported != 0 {
+		t.Fatalf("want no spans exported, got %d", exported)
+	}
+}
+
+func TestStartOutsideSpan(t *testing.T) {
+	ctx, s := trace.Start(context.Background(), "orphan")
+	s.SetAttribute("k", "v")
+	s.End(nil)
+	h := http.Header{}
+	trace.Inject(ctx, h)
+	if s != nil || len(h) != 0 {
+		t.Fatalf("want no span or headers outside a span, got %v %v", s, h)
+	}
+
+	var tracer *trace.Tracer
+	if _, s := tracer.Start(context.Background(), "request", trace.SpanContext{}); s != nil {
+		t.Fatalf("want no span from a nil Tracer, got %v", s)
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := trace.NewTracer(trace.NewJSONExporter(&buf))
+	_, s := tracer.Start(context.Background(), "request", trace.SpanContext{})
+	s.End(nil)
+
+	var d trace.SpanData
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "request" || d.TraceID != s.Context().TraceID.String() || d.ParentID != "" {
+		t.Fatalf("want a new trace's root span, got %+v", d)
+	}
+}
@@ -0,0 +1,292 @@
+package innsecure
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/kit/endpoint"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/form3tech/innsecure/trace"
+)
+
+// Tracing returns middleware which starts the span of each request,
+// continuing the caller's trace if it sent a traceparent header. The span is
+// named after the route matched, once it is known.
+//
+// It should wrap AccessLog, so that access log entries hold the trace ID.
+func Tracing(t *trace.Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := t.Start(r.Context(), r.Method, trace.Extract(r.Header))
+			span.SetAttribute("http.method", r.Method)
+			lw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				span.SetAttribute("http.status_code", strconv.Itoa(lw.status))
+				span.End(nil)
+			}()
+			next.ServeHTTP(lw, r.WithContext(ctx))
+		})
+	}
+}
+
+// GRPCTracing returns a gRPC interceptor which starts the span of each call,
+// continuing the caller's trace if it sent "traceparent" metadata.
+func GRPCTracing(t *trace.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		h := http.Header{}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, k := range []string{trace.TraceparentHeader, trace.TracestateHeader} {
+				for _, v := range md.Get(k) {
+					h.Add(k, v)
+				}
+			}
+		}
+		ctx, span := t.Start(ctx, info.FullMethod, trace.Extract(h))
+		defer func() { span.End(err) }()
+		return handler(ctx, req)
+	}
+}
+
+// TraceEndpoint returns middleware which records a span of each call to the
+// named endpoint.
+func TraceEndpoint(method string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := trace.Start(ctx, "endpoint."+method)
+			defer func() { span.End(err) }()
+			return next(ctx, request)
+		}
+	}
+}
+
+// traceCall starts a span of a call to a service or repository method.
+func traceCall(ctx context.Context, name string) (context.Context, func(error)) {
+	ctx, span := trace.Start(ctx, name)
+	return ctx, span.End
+}
+
+// TraceService returns a Service which records a span of each call to s.
+func TraceService(s Service) Service {
+	return tracingService{next: s}
+}
+
+type tracingService struct {
+	next Service
+}
+
+func (s tracingService) CreateBooking(ctx context.Context, u *User, b Booking) (_ *Booking, err error) {
+	ctx, end := traceCall(ctx, "service.CreateBooking")
+	defer func() { end(err) }()
+	return s.next.CreateBooking(ctx, u, b)
+}
+
+func (s tracingService) ListBookings(ctx context.Context, u *User) (_ *Listing, err error) {
+	ctx, end := traceCall(ctx, "service.ListBookings")
+	defer func() { end(err) }()
+	return s.next.ListBookings(ctx, u)
+}
+
+func (s tracingService) GetBookingByID(ctx context.Context, u *User, ID string) (_ *Booking, err error) {
+	ctx, end := traceCall(ctx, "service.GetBookingByID")
+	defer func() { end(err) }()
+	return s.next.GetBookingByID(ctx, u, ID)
+}
+
+func (s tracingService) EraseGuest(ctx context.Context, u *User, r ErasureRequest) (_ *Erasure, err error) {
+	ctx, end := traceCall(ctx, "service.EraseGuest")
+	defer func() { end(err) }()
+	return s.next.EraseGuest(ctx, u, r)
+}
+
+func (s tracingService) CancelBooking(ctx context.Context, u *User, ID string) (_ *Booking, err error) {
+	ctx, end := traceCall(ctx, "service.CancelBooking")
+	defer func() { end(err) }()
+	return s.next.CancelBooking(ctx, u, ID)
+}
+
+func (s tracingService) ImportBookings(ctx context.Context, u *User, rows []ImportRow) (_ *ImportReport, err error) {
+	ctx, end := traceCall(ctx, "service.ImportBookings")
+	defer func() { end(err) }()
+	return s.next.ImportBookings(ctx, u, rows)
+}
+
+func (s tracingService) ExportBookings(ctx context.Context, u *User, fn func(Booking) error) (err error) {
+	ctx, end := traceCall(ctx, "service.ExportBookings")
+	defer func() { end(err) }()
+	return s.next.ExportBookings(ctx, u, fn)
+}
+
+func (s tracingService) CreateFeedToken(ctx context.Context, u *User) (_ *FeedToken, err error) {
+	ctx, end := traceCall(ctx, "service.CreateFeedToken")
+	defer func() { end(err) }()
+	return s.next.CreateFeedToken(ctx, u)
+}
+
+func (s tracingService) ListFeedTokens(ctx context.Context, u *User) (_ *FeedTokenListing, err error) {
+	ctx, end := traceCall(ctx, "service.ListFeedTokens")
+	defer func() { end(err) }()
+	return s.next.ListFeedTokens(ctx, u)
+}
+
+func (s tracingService) RevokeFeedToken(ctx context.Context, u *User, ID string) (_ *FeedToken, err error) {
+	ctx, end := traceCall(ctx, "service.RevokeFeedToken")
+	defer func() { end(err) }()
+	return s.next.RevokeFeedToken(ctx, u, ID)
+}
+
+func (s tracingService) FeedBookings(ctx context.Context, hotelID int, token string) (_ *Listing, err error) {
+	ctx, end := traceCall(ctx, "service.FeedBookings")
+	defer func() { end(err) }()
+	return s.next.FeedBookings(ctx, hotelID, token)
+}
+
+func (s tracingService) WatchBookings(ctx context.Context, u *User, after int64, fn func(BookingEvent) error) (err error) {
+	ctx, end := traceCall(ctx, "service.WatchBookings")
+	defer func() { end(err) }()
+	return s.next.WatchBookings(ctx, u, after, fn)
+}
+
+func (s tracingService) CreateWebhook(ctx context.Context, u *User, w Webhook) (_ *Webhook, err error) {
+	ctx, end := traceCall(ctx, "service.CreateWebhook")
+	defer func() { end(err) }()
+	return s.next.CreateWebhook(ctx, u, w)
+}
+
+func (s tracingService) ListWebhooks(ctx context.Context, u *User) (_ *WebhookListing, err error) {
+	ctx, end := traceCall(ctx, "service.ListWebhooks")
+	defer func() { end(err) }()
+	return s.next.ListWebhooks(ctx, u)
+}
+
+func (s tracingService) DeleteWebhook(ctx context.Context, u *User, ID string) (_ *Webhook, err error) {
+	ctx, end := traceCall(ctx, "service.DeleteWebhook")
+	defer func() { end(err) }()
+	return s.next.DeleteWebhook(ctx, u, ID)
+}
+
+func (s tracingService) ListWebhookDeliveries(ctx context.Context, u *User, webhookID string) (_ *WebhookDeliveryListing, err error) {
+	ctx, end := traceCall(ctx, "service.ListWebhookDeliveries")
+	defer func() { end(err) }()
+	return s.next.ListWebhookDeliveries(ctx, u, webhookID)
+}
+
+// TraceRepository returns a Repository which records a span of each call to
+// r.
+func TraceRepository(r Repository) Repository {
+	return tracingRepository{next: r}
+}
+
+type tracingRepository struct {
+	next Repository
+}
+
+func (r tracingRepository) Insert(ctx context.Context, in Booking) (err error) {
+	ctx, end := traceCall(ctx, "repository.Insert")
+	defer func() { end(err) }()
+	return r.next.Insert(ctx, in)
+}
+
+func (r tracingRepository) List(ctx context.Context, hotelID int) (_ []Booking, err error) {
+	ctx, end := traceCall(ctx, "repository.List")
+	defer func() { end(err) }()
+	return r.next.List(ctx, hotelID)
+}
+
+func (r tracingRepository) ByID(ctx context.Context, hotelID int, ID string) (_ *Booking, err error) {
+	ctx, end := traceCall(ctx, "repository.ByID")
+	defer func() { end(err) }()
+	return r.next.ByID(ctx, hotelID, ID)
+}
+
+func (r tracingRepository) EraseGuest(ctx context.Context, hotelID int, name, requestedBy string) (_ int, err error) {
+	ctx, end := traceCall(ctx, "repository.EraseGuest")
+	defer func() { end(err) }()
+	return r.next.EraseGuest(ctx, hotelID, name, requestedBy)
+}
+
+func (r tracingRepository) Cancel(ctx context.Context, hotelID int, ID string, version int) (_ *Booking, err error) {
+	ctx, end := traceCall(ctx, "repository.Cancel")
+	defer func() { end(err) }()
+	return r.next.Cancel(ctx, hotelID, ID, version)
+}
+
+func (r tracingRepository) Import(ctx context.Context, in []Booking) (err error) {
+	ctx, end := traceCall(ctx, "repository.Import")
+	defer func() { end(err) }()
+	return r.next.Import(ctx, in)
+}
+
+func (r tracingRepository) Export(ctx context.Context, hotelID int, fn func(Booking) error) (err error) {
+	ctx, end := traceCall(ctx, "repository.Export")
+	defer func() { end(err) }()
+	return r.next.Export(ctx, hotelID, fn)
+}
+
+func (r tracingRepository) InsertFeedToken(ctx context.Context, t FeedToken, hash []byte) (err error) {
+	ctx, end := traceCall(ctx, "repository.InsertFeedToken")
+	defer func() { end(err) }()
+	return r.next.InsertFeedToken(ctx, t, hash)
+}
+
+func (r tracingRepository) FeedTokens(ctx context.Context, hotelID int) (_ []FeedToken, err error) {
+	ctx, end := traceCall(ctx, "repository.FeedTokens")
+	defer func() { end(err) }()
+	return r.next.FeedTokens(ctx, hotelID)
+}
+
+func (r tracingRepository) RevokeFeedToken(ctx context.Context, hotelID int, ID string) (_ *FeedToken, err error) {
+	ctx, end := traceCall(ctx, "repository.RevokeFeedToken")
+	defer func() { end(err) }()
+	return r.next.RevokeFeedToken(ctx, hotelID, ID)
+}
+
+func (r tracingRepository) FeedTokenValid(ctx context.Context, hotelID int, hash []byte) (_ bool, err error) {
+	ctx, end := traceCall(ctx, "repository.FeedTokenValid")
+	defer func() { end(err) }()
+	return r.next.FeedTokenValid(ctx, hotelID, hash)
+}
+
+func (r tracingRepository) Events(ctx context.Context, hotelID int, after int64, limit int) (_ []BookingEvent, err error) {
+	ctx, end := traceCall(ctx, "repository.Events")
+	defer func() { end(err) }()
+	return r.next.Events(ctx, hotelID, after, limit)
+}
+
+func (r tracingRepository) LastEventID(ctx context.Context, hotelID int) (_ int64, err error) {
+	ctx, end := traceCall(ctx, "repository.LastEventID")
+	defer func() { end(err) }()
+	return r.next.LastEventID(ctx, hotelID)
+}
+
+func (r tracingRepository) InsertWebhook(ctx context.Context, w Webhook) (err error) {
+	ctx, end := traceCall(ctx, "repository.InsertWebhook")
+	defer func() { end(err) }()
+	return r.next.InsertWebhook(ctx, w)
+}
+
+func (r tracingRepository) Webhooks(ctx context.Context, hotelID int) (_ []Webhook, err error) {
+	ctx, end := traceCall(ctx, "repository.Webhooks")
+	defer func() { end(err) }()
+	return r.next.Webhooks(ctx, hotelID)
+}
+
+func (r tracingRepository) WebhookByID(ctx context.Context, hotelID int, ID string) (_ *Webhook, err error) {
+	ctx, end := traceCall(ctx, "repository.WebhookByID")
+	defer func() { end(err) }()
+	return r.next.WebhookByID(ctx, hotelID, ID)
+}
+
+func (r tracingRepository) DeleteWebhook(ctx context.Context, hotelID int, ID string) (_ *Webhook, err error) {
+	ctx, end := traceCall(ctx, "repository.DeleteWebhook")
+	defer func() { end(err) }()
+	return r.next.DeleteWebhook(ctx, hotelID, ID)
+}
+
+func (r tracingRepository) WebhookDeliveries(ctx context.Context, hotelID int, webhookID string, limit int) (_ []WebhookDelivery, err error) {
+	ctx, end := traceCall(ctx, "repository.WebhookDeliveries")
+	defer func() { end(err) }()
+	return r.next.WebhookDeliveries(ctx, hotelID, webhookID, limit)
+}
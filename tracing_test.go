@@ -0,0 +1,98 @@
+package innsecure_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/trace"
+	"github.com/go-kit/kit/log"
+)
+
+// spanRecorder is a trace.Exporter recording the spans exported.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (r *spanRecorder) Export(d trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, d)
+}
+
+func TestTracing(t *testing.T) {
+	r := repo{
+		byID: func(context.Context, int, string) (*innsecure.Booking, error) {
+			return nil, nil
+		},
+	}
+	var spans spanRecorder
+	var access logLines
+	e := innsecure.MakeServerEndpoints(innsecure.TraceService(innsecure.NewBookingService(innsecure.TraceRepository(r))), asAdmin)
+	h := innsecure.MakeHTTPHandler(e.With(innsecure.TraceEndpoint), log.NewNopLogger())
+	h = innsecure.AccessLog(&access)(h)
+	h = innsecure.Tracing(trace.NewTracer(&spans))(h)
+
+	req := httptest.NewRequest("GET", "/v1/hotels/123/bookings/"+foundID, nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := []string{
+		"repository.ByID",
+		"service.GetBookingByID",
+		"endpoint.GetBookingByID",
+		"GET /v1/hotels/{org_id}/bookings/{id}",
+	}
+	if len(spans.spans) != len(want) {
+		t.Fatalf("want spans %q, got %+v", want, spans.spans)
+	}
+	for i, s := range spans.spans {
+		if s.Name != want[i] || s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("want span %q in the caller's trace, got %+v", want[i], s)
+		}
+		if i > 0 && spans.spans[i-1].ParentID != s.SpanID {
+			t.Errorf("want %q to be the parent of %q", s.Name, spans.spans[i-1].Name)
+		}
+	}
+	if root := spans.spans[3]; root.ParentID != "00f067aa0ba902b7" || root.Attributes["http.status_code"] != "404" || root.Error != "" {
+		t.Errorf("want the request's span to continue the caller's, got %+v", root)
+	}
+	if spans.spans[2].Error == "" {
+		t.Errorf("want the endpoint's span to record its error, got %+v", spans.spans[2])
+	}
+	if len(access) != 1 || access[0]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("want the trace ID logged, got %v", access)
+	}
+}
+
+func TestWebhookDeliveriesCarryTraceContext(t *testing.T) {
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var spans spanRecorder
+	store := &webhookStore{due: []innsecure.DueWebhookDelivery{{
+		Delivery: innsecure.WebhookDelivery{ID: 7, HotelID: 123, WebhookID: "w", Status: innsecure.DeliveryPending},
+		URL:      srv.URL,
+		Secret:   "secret",
+	}}}
+	d := innsecure.NewWebhookDispatcher(store, time.Second, true, log.NewNopLogger())
+	d.SetTracer(trace.NewTracer(&spans))
+	d.RunOnce(context.Background())
+
+	if len(spans.spans) != 1 {
+		t.Fatalf("want the delivery traced, got %+v", spans.spans)
+	}
+	sc, err := trace.ParseTraceparent(traceparent)
+	if err != nil || sc.TraceID.String() != spans.spans[0].TraceID || sc.SpanID.String() != spans.spans[0].SpanID {
+		t.Fatalf("want the delivery to carry its trace context, got %q (%v)", traceparent, err)
+	}
+}
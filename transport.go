@@ -8,9 +8,10 @@ import (
 
 	"github.com/gorilla/mux"
 
-	stdjwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
 )
 
@@ -24,47 +25,216 @@ var (
 
 // MakeHTTPHandler mounts all of the service endpoints into an http.Handler.
 // Useful in a profilesvc server.
-func MakeHTTPHandler(e Endpoints, logger log.Logger) http.Handler {
+func MakeHTTPHandler(e Endpoints, logger log.Logger, opts ...HandlerOption) http.Handler {
+	o := handlerOptions{deprecations: map[string]Deprecation{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	r := mux.NewRouter()
+	r.Use(noteRoute)
 	options := []httptransport.ServerOption{
-		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerErrorHandler(transport.ErrorHandlerFunc(logError(logger))),
 		httptransport.ServerErrorEncoder(encodeError),
-		httptransport.ServerBefore(jwt.HTTPToContext()),
+		httptransport.ServerBefore(jwt.HTTPToContext(), conditionsToContext),
 	}
 
 	// GET		/hotels/:hotelID/bookings 		retrieves a list of bookings
 	// POST		/hotels/:hotelID/bookings 		adds another booking
+	// GET		/hotels/:hotelID/bookings/stream 	streams changes to bookings
 	// GET		/hotels/:hotelID/bookings/:ID 	adds another booking
+	// POST		/hotels/:hotelID/bookings/:ID/cancel 	cancels a booking
+	// POST		/hotels/:hotelID/bookings:import 	creates bookings from a file
+	// GET		/hotels/:hotelID/bookings:export 	streams all bookings
+	// GET		/hotels/:hotelID/bookings.ics 		calendar feed, authenticated by feed token
+	// POST		/hotels/:hotelID/feed-tokens 		creates a calendar feed token
+	// GET		/hotels/:hotelID/feed-tokens 		lists calendar feed tokens
+	// DELETE	/hotels/:hotelID/feed-tokens/:ID 	revokes a calendar feed token
+	// POST		/hotels/:hotelID/webhooks 		subscribes a URL to booking changes
+	// GET		/hotels/:hotelID/webhooks 		lists webhooks
+	// DELETE	/hotels/:hotelID/webhooks/:ID 		deletes a webhook
+	// GET		/hotels/:hotelID/webhooks/:ID/deliveries 	lists a webhook's deliveries
+	// POST		/hotels/:hotelID/erasures 		erases a guest's personal data
+	// GET		/openapi.json 				describes the API
+	//
+	// Every route is served under the prefix of each version, such as
+	// /v1/hotels/:hotelID/bookings, and for v1 without a prefix too. Every
+	// route must be described in openapi.json, which is also used to
+	// validate request bodies.
 
-	r.Methods("GET").Path("/hotels/{org_id}/bookings").Handler(httptransport.NewServer(
+	var routes routeTable
+
+	routes.add("GET", "/hotels/{org_id}/bookings",
 		e.ListBookings,
 		httptransport.NopRequestDecoder,
 		encodeResponse,
-		options...,
-	))
-	r.Methods("POST").Path("/hotels/{org_id}/bookings").Handler(httptransport.NewServer(
+	)
+	routes.add("POST", "/hotels/{org_id}/bookings",
 		e.CreateBooking,
-		decodeCreateBookingRequest,
+		validatingDecoder("POST", "/hotels/{org_id}/bookings", ErrInvalidBooking, decodeCreateBookingRequest),
 		encodeResponseWithStatus(http.StatusCreated),
-		options...,
-	))
-	r.Methods("GET").Path("/hotels/{org_id}/bookings/{id}").Handler(httptransport.NewServer(
+		httptransport.ServerBefore(idempotencyKeyToContext),
+	)
+	// Registered before bookings/{id}, which would otherwise match it.
+	routes.add("GET", "/hotels/{org_id}/bookings/stream",
+		e.WatchBookings,
+		decodeWatchRequest,
+		encodeEventStream,
+	)
+	routes.add("GET", "/hotels/{org_id}/bookings/{id}",
 		e.GetBookingByID,
 		decodeID,
 		encodeResponse,
-		options...,
-	))
+	)
+	routes.add("POST", "/hotels/{org_id}/bookings/{id}/cancel",
+		e.CancelBooking,
+		decodeID,
+		encodeResponse,
+	)
+	routes.add("POST", "/hotels/{org_id}/bookings:import",
+		e.ImportBookings,
+		decodeImportRequest,
+		encodeResponse,
+	)
+	routes.add("GET", "/hotels/{org_id}/bookings:export",
+		e.ExportBookings,
+		httptransport.NopRequestDecoder,
+		encodeExportResponse,
+		httptransport.ServerBefore(exportFormatToContext),
+	)
+	routes.add("GET", "/hotels/{org_id}/bookings.ics",
+		e.FeedBookings,
+		decodeFeedRequest,
+		encodeCalendarResponse,
+	)
+	routes.add("POST", "/hotels/{org_id}/feed-tokens",
+		e.CreateFeedToken,
+		httptransport.NopRequestDecoder,
+		encodeResponseWithStatus(http.StatusCreated),
+	)
+	routes.add("GET", "/hotels/{org_id}/feed-tokens",
+		e.ListFeedTokens,
+		httptransport.NopRequestDecoder,
+		encodeResponse,
+	)
+	routes.add("DELETE", "/hotels/{org_id}/feed-tokens/{id}",
+		e.RevokeFeedToken,
+		decodeID,
+		encodeResponse,
+	)
+	routes.add("POST", "/hotels/{org_id}/webhooks",
+		e.CreateWebhook,
+		validatingDecoder("POST", "/hotels/{org_id}/webhooks", ErrInvalidWebhook, decodeWebhookRequest),
+		encodeResponseWithStatus(http.StatusCreated),
+	)
+	routes.add("GET", "/hotels/{org_id}/webhooks",
+		e.ListWebhooks,
+		httptransport.NopRequestDecoder,
+		encodeResponse,
+	)
+	routes.add("DELETE", "/hotels/{org_id}/webhooks/{id}",
+		e.DeleteWebhook,
+		decodeID,
+		encodeResponse,
+	)
+	routes.add("GET", "/hotels/{org_id}/webhooks/{id}/deliveries",
+		e.ListWebhookDeliveries,
+		decodeID,
+		encodeResponse,
+	)
+	routes.add("POST", "/hotels/{org_id}/erasures",
+		e.EraseGuest,
+		validatingDecoder("POST", "/hotels/{org_id}/erasures", ErrInvalidErasure, decodeErasureRequest),
+		encodeResponse,
+	)
+	routes.addHandler("GET", "/openapi.json", http.HandlerFunc(serveOpenAPISpec))
+
+	mounted := map[string]bool{}
+	for _, v := range append([]APIVersion{V1}, o.versions...) {
+		for _, rt := range routes {
+			mounted[rt.mount(r, "/"+v.Name, v, options, o.deprecations)] = true
+			if v.Name == V1.Name {
+				mounted[rt.mount(r, "", v, options, o.deprecations)] = true
+			}
+		}
+	}
+	for route := range o.deprecations {
+		if !mounted[route] {
+			logger.Log("msg", "deprecation of unknown route", "route", route)
+		}
+	}
 	return r
 }
 
+// route is a route of the HTTP API, served by every version.
+type route struct {
+	method, path string
+	endpoint     endpoint.Endpoint
+	dec          httptransport.DecodeRequestFunc
+	enc          httptransport.EncodeResponseFunc
+	options      []httptransport.ServerOption
+	// handler serves the route instead, if it has no endpoint.
+	handler http.Handler
+}
+
+type routeTable []route
+
+// add adds a route served by an endpoint.
+func (t *routeTable) add(method, path string, e endpoint.Endpoint, dec httptransport.DecodeRequestFunc, enc httptransport.EncodeResponseFunc, options ...httptransport.ServerOption) {
+	*t = append(*t, route{method: method, path: path, endpoint: e, dec: dec, enc: enc, options: options})
+}
+
+// addHandler adds a route served by a plain handler, the same in every
+// version.
+func (t *routeTable) addHandler(method, path string, h http.Handler) {
+	*t = append(*t, route{method: method, path: path, handler: h})
+}
+
+// mount registers the route of version v under the given prefix, with its
+// deprecation, if any, and returns its name.
+func (rt route) mount(r *mux.Router, prefix string, v APIVersion, options []httptransport.ServerOption, deprecations map[string]Deprecation) string {
+	name := rt.method + " " + prefix + rt.path
+	h := rt.handler
+	if h == nil {
+		key := rt.method + " " + rt.path
+		h = httptransport.NewServer(
+			rt.endpoint,
+			v.decoder(key, rt.dec),
+			v.encoder(key, rt.enc),
+			append(options[:len(options):len(options)], rt.options...)...,
+		)
+	}
+	if d, ok := deprecations[name]; ok {
+		h = d.handler(h)
+	}
+	r.Methods(rt.method).Path(prefix + rt.path).Handler(h)
+	return name
+}
+
 func decodeCreateBookingRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
 	var p Booking
-	if e := json.NewDecoder(r.Body).Decode(&p); e != nil {
-		return nil, ErrBadRequest
+	if err := decodeJSON(r, &p); err != nil {
+		return nil, err
 	}
 	return p, nil
 }
 
+func decodeErasureRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var e ErasureRequest
+	if err := decodeJSON(r, &e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func decodeWebhookRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var w Webhook
+	if err := decodeJSON(r, &w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
 func decodeID(_ context.Context, r *http.Request) (request interface{}, err error) {
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
@@ -75,11 +245,10 @@ func decodeID(_ context.Context, r *http.Request) (request interface{}, err erro
 }
 
 // Returns an EncodeResponseFunc that will set the given status code before
-// encode the JSON response with encodeResponse.
+// encode the JSON response as encodeResponse does.
 func encodeResponseWithStatus(code int) httptransport.EncodeResponseFunc {
 	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
-		w.WriteHeader(code)
-		return encodeResponse(ctx, w, response)
+		return writeResponse(ctx, w, code, response)
 	}
 }
 
@@ -88,54 +257,57 @@ func encodeResponseWithStatus(code int) httptransport.EncodeResponseFunc {
 // reason to provide anything more specific. It's certainly possible to
 // specialize on a per-response (per-method) basis.
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	return writeResponse(ctx, w, http.StatusOK, response)
+}
+
+// writeResponse writes response as JSON with the given status code. Responses
+// with an entity tag have it set in the ETag header, and if it matches the
+// request's If-None-Match header, a 304 is written without a body instead.
+func writeResponse(ctx context.Context, w http.ResponseWriter, code int, response interface{}) error {
+	if t, ok := response.(interface{ ETag() string }); ok {
+		etag := t.ETag()
+		w.Header().Set("ETag", etag)
+		if c, ok := ctx.Value(conditionsKey{}).(conditions); ok && matchETag(c.ifNoneMatch, etag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
 	return json.NewEncoder(w).Encode(response)
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+// encodeError reports an error as an RFC 7807 problem.
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
 	if err == nil {
 		panic("encodeError with nil error")
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	code := codeFrom(err)
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": http.StatusText(code),
+	e := AsError(err)
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(problem{
+		Type:      ProblemTypePrefix + e.Code,
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Message,
+		Code:      e.Code,
+		Fields:    e.Fields,
+		RequestID: RequestIDFromContext(ctx),
 	})
 }
 
+// problem is the RFC 7807 representation of an *Error.
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail"`
+	Code   string       `json:"code"`
+	Fields []FieldError `json:"fields,omitempty"`
+	// RequestID identifies the request, for reporting the error.
+	RequestID string `json:"request_id,omitempty"`
+}
+
 func codeFrom(err error) int {
-	switch err {
-	case ErrNotFound:
-		return http.StatusNotFound
-	case ErrBadRequest:
-		return http.StatusBadRequest
-	case ErrInvalidBooking:
-		return http.StatusBadRequest
-	case ErrUnauthorized:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenContextMissing:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenInvalid:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenExpired:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenMalformed:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenNotActive:
-		return http.StatusUnauthorized
-	case jwt.ErrUnexpectedSigningMethod:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenMalformed:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenExpired:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenNotActive:
-		return http.StatusUnauthorized
-	case jwt.ErrTokenInvalid:
-		return http.StatusUnauthorized
-	case stdjwt.ErrSignatureInvalid:
-		return http.StatusUnauthorized
-	}
-	return http.StatusInternalServerError
+	return AsError(err).Status
 }
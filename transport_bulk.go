@@ -0,0 +1,246 @@
+package innsecure
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxImportBytes limits the size of an import file.
+	maxImportBytes = 32 << 20
+	// maxImportRows limits the number of bookings in an import file.
+	maxImportRows = 50000
+)
+
+// Media types of import and export files.
+const (
+	mediaTypeNDJSON = "application/x-ndjson"
+	mediaTypeCSV    = "text/csv"
+)
+
+// decodeImportRequest reads the bookings of an NDJSON or CSV import file,
+// chosen by the Content-Type of the request. Every row is checked against the
+// NewBooking schema, as a booking created on its own would be, and rows which
+// do not match are returned with their errors rather than failing the whole
+// import.
+func decodeImportRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body := http.MaxBytesReader(nil, r.Body, maxImportBytes)
+
+	var (
+		rows []ImportRow
+		err  error
+	)
+	switch mediaType {
+	case mediaTypeNDJSON:
+		rows, err = readNDJSONImport(body)
+	case mediaTypeCSV:
+		hotelID, _ := strconv.Atoi(mux.Vars(r)["org_id"])
+		rows, err = readCSVImport(body, hotelID)
+	default:
+		return nil, withFields(ErrBadRequest, FieldError{
+			Field:   "Content-Type",
+			Message: "must be " + mediaTypeNDJSON + " or " + mediaTypeCSV,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > maxImportRows {
+		return nil, withFields(ErrBadRequest, FieldError{
+			Field:   "body",
+			Message: fmt.Sprintf("must have at most %d rows", maxImportRows),
+		})
+	}
+	return rows, nil
+}
+
+// readNDJSONImport reads one booking from each non-blank line of r.
+func readNDJSONImport(r io.Reader) ([]ImportRow, error) {
+	var rows []ImportRow
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			rows = append(rows, ImportRow{Line: line, Errors: []FieldError{{Field: "body", Message: "must be valid JSON"}}})
+			continue
+		}
+		rows = append(rows, importRow(line, v))
+		if len(rows) > maxImportRows {
+			break
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, importReadError(err)
+	}
+	return rows, nil
+}
+
+// readCSVImport reads one booking from each record of r after the header,
+// which must name the arrive, leave and name columns of CSVColumns.
+// Bookings are for hotelID unless the file has a hotel_id column.
+func readCSVImport(r io.Reader, hotelID int) ([]ImportRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, importReadError(err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, name := range []string{"arrive", "leave", "name"} {
+		if _, ok := cols[name]; !ok {
+			return nil, withFields(ErrBadRequest, FieldError{Field: "header", Message: "must have a " + name + " column"})
+		}
+	}
+
+	var rows []ImportRow
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, importReadError(err)
+		}
+		if len(rec) != len(header) {
+			rows = append(rows, ImportRow{Line: line, Errors: []FieldError{{Field: "body", Message: fmt.Sprintf("must have %d fields", len(header))}}})
+			continue
+		}
+
+		v := map[string]interface{}{
+			"type":     "Booking",
+			"hotel_id": float64(hotelID),
+			"arrive":   rec[cols["arrive"]],
+			"leave":    rec[cols["leave"]],
+			"name":     rec[cols["name"]],
+		}
+		if i, ok := cols["hotel_id"]; ok {
+			n, err := strconv.Atoi(strings.TrimSpace(rec[i]))
+			if err != nil {
+				rows = append(rows, ImportRow{Line: line, Errors: []FieldError{{Field: "hotel_id", Message: "must be an integer"}}})
+				continue
+			}
+			v["hotel_id"] = float64(n)
+		}
+		rows = append(rows, importRow(line, v))
+		if len(rows) > maxImportRows {
+			return rows, nil
+		}
+	}
+}
+
+// importRow checks v, decoded from JSON, against the NewBooking schema and
+// returns it as a row to import.
+func importRow(line int, v interface{}) ImportRow {
+	sc := spec.resolve(&schema{Ref: "#/components/schemas/NewBooking"})
+	if errs := spec.validate(sc, "", v); len(errs) > 0 {
+		return ImportRow{Line: line, Errors: errs}
+	}
+	// v matches the schema, so cannot fail to decode.
+	raw, _ := json.Marshal(v)
+	row := ImportRow{Line: line}
+	json.Unmarshal(raw, &row.Booking)
+	return row
+}
+
+// importReadError converts an error reading an import file to one which can
+// be reported to the client.
+func importReadError(err error) error {
+	var pe *csv.ParseError
+	switch {
+	case errors.As(err, &pe):
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: fmt.Sprintf("line %d: %s", pe.Line, pe.Err)})
+	case errors.Is(err, bufio.ErrTooLong):
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: "lines must be at most 1MB"})
+	case isBodyTooLarge(err):
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: fmt.Sprintf("must be at most %dMB", maxImportBytes>>20)})
+	}
+	return ErrBadRequest
+}
+
+type exportFormatKey struct{}
+
+// exportFormatToContext chooses the media type of an export from the Accept
+// header of the request: CSV if the client accepts it, and otherwise NDJSON.
+func exportFormatToContext(ctx context.Context, r *http.Request) context.Context {
+	format := mediaTypeNDJSON
+	for _, v := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(v, ",") {
+			if mt, _, err := mime.ParseMediaType(t); err == nil && mt == mediaTypeCSV {
+				format = mediaTypeCSV
+			}
+		}
+	}
+	return context.WithValue(ctx, exportFormatKey{}, format)
+}
+
+// encodeExportResponse writes each booking of a BookingStream as it is read,
+// in the format chosen by exportFormatToContext. Errors before the first
+// booking is written are reported as usual; later ones abort the response so
+// that the client cannot mistake it for a complete export. Each booking has
+// the server's WriteTimeout to be written in, so a client which stops reading
+// does not hold the export open.
+func encodeExportResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	format, _ := ctx.Value(exportFormatKey{}).(string)
+	var (
+		cw      = csv.NewWriter(w)
+		enc     = json.NewEncoder(w)
+		started bool
+	)
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", format+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if format == mediaTypeCSV {
+			return cw.Write(CSVColumns)
+		}
+		return nil
+	}
+
+	err := response.(BookingStream)(func(b Booking) error {
+		extendWriteDeadline(ctx)
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if format == mediaTypeCSV {
+			return cw.Write(b.CSVRecord())
+		}
+		return enc.Encode(b)
+	})
+	if err == nil && !started {
+		extendWriteDeadline(ctx)
+		err = start()
+	}
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	if err != nil && started {
+		panic(http.ErrAbortHandler)
+	}
+	return err
+}
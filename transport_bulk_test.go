@@ -0,0 +1,171 @@
+package innsecure_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+func TestImportReportsEachRow(t *testing.T) {
+	var imported []innsecure.Booking
+	r := repo{
+		bulk: func(_ context.Context, in []innsecure.Booking) error {
+			imported = in
+			return nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(innsecure.NewBookingService(r), asAdmin), log.NewNopLogger())
+
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []innsecure.ImportResult
+	}{
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body: `{"type": "Booking", "hotel_id": 123, "arrive": "2021-08-13", "leave": "2021-08-15", "name": "Jane Guest"}
+{"type": "Booking", "hotel_id": 123, "arrive": "13/08/2021", "leave": "2021-08-15", "name": "John Guest"}
+
+{"type": "Booking", "hotel_id": 456, "arrive": "2021-08-13", "leave": "2021-08-15", "name": "Joan Guest"}
+not json
+`,
+			want: []innsecure.ImportResult{
+				{Line: 1, Status: innsecure.ImportCreated},
+				{Line: 2, Status: innsecure.ImportRejected, Errors: []innsecure.FieldError{{Field: "arrive"}}},
+				{Line: 4, Status: innsecure.ImportRejected, Errors: []innsecure.FieldError{{Field: "hotel_id"}}},
+				{Line: 5, Status: innsecure.ImportRejected, Errors: []innsecure.FieldError{{Field: "body"}}},
+			},
+		},
+		{
+			name:        "csv",
+			contentType: "text/csv; charset=utf-8",
+			body:        "name,arrive,leave\nJane Guest,2021-08-13,2021-08-15\n,2021-08-13,2021-08-15\n",
+			want: []innsecure.ImportResult{
+				{Line: 2, Status: innsecure.ImportCreated},
+				{Line: 3, Status: innsecure.ImportRejected, Errors: []innsecure.FieldError{{Field: "name"}}},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			imported = nil
+			req := httptest.NewRequest("POST", "/hotels/123/bookings:import", strings.NewReader(c.body))
+			req.Header.Set("Content-Type", c.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("want=%d, got=%d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var got innsecure.ImportReport
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Results) != len(c.want) {
+				t.Fatalf("want %d results, got %+v", len(c.want), got.Results)
+			}
+			for i, want := range c.want {
+				res := got.Results[i]
+				if res.Line != want.Line || res.Status != want.Status || len(res.Errors) != len(want.Errors) {
+					t.Fatalf("result %d: want=%+v, got=%+v", i, want, res)
+				}
+				for j := range want.Errors {
+					if res.Errors[j].Field != want.Errors[j].Field {
+						t.Fatalf("result %d: want error for %s, got %+v", i, want.Errors[j].Field, res.Errors[j])
+					}
+				}
+				if (res.Status == innsecure.ImportCreated) != (res.ID != "") {
+					t.Fatalf("result %d: want an ID only for created bookings, got %+v", i, res)
+				}
+			}
+			if len(imported) != got.Created || got.Created+got.Rejected != len(c.want) {
+				t.Fatalf("want counts to match results, got %+v with %d imported", got, len(imported))
+			}
+		})
+	}
+}
+
+func TestImportRejectsUnknownContentType(t *testing.T) {
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(innsecure.NewBookingService(repo{}), asAdmin), log.NewNopLogger())
+	req := httptest.NewRequest("POST", "/hotels/123/bookings:import", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/xml")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want=%d, got=%d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestExportStreamsBookings(t *testing.T) {
+	r := repo{
+		export: func(_ context.Context, hotelID int, fn func(innsecure.Booking) error) error {
+			for _, name := range []string{"Jane Guest", "=HYPERLINK()"} {
+				if err := fn(innsecure.Booking{Type: "Booking", ID: "a", HotelID: hotelID, Name: name, Status: innsecure.BookingConfirmed}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(innsecure.NewBookingService(r), asAdmin), log.NewNopLogger())
+
+	export := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/hotels/123/bookings:export", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("want=%d, got=%d", http.StatusOK, w.Code)
+		}
+		return w
+	}
+
+	w := export("application/x-ndjson")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, got %q", w.Body.String())
+	}
+	var b innsecure.Booking
+	if err := json.Unmarshal([]byte(lines[0]), &b); err != nil || b.Name != "Jane Guest" || b.HotelID != 123 {
+		t.Fatalf("want first booking, got %+v (%v)", b, err)
+	}
+
+	w = export("text/csv")
+	want := "id,version,hotel_id,arrive,leave,name,status\na,0,123,,,Jane Guest,confirmed\na,0,123,,,'=HYPERLINK(),confirmed\n"
+	if got := w.Body.String(); got != want {
+		t.Fatalf("want=%q, got=%q", want, got)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("want CSV, got %q", ct)
+	}
+}
+
+func TestCSVRecordEscapesFormulae(t *testing.T) {
+	for name, want := range map[string]string{
+		"":              "",
+		"Jane Guest":    "Jane Guest",
+		"=HYPERLINK(1)": "'=HYPERLINK(1)",
+		"+1":            "'+1",
+		"-1":            "'-1",
+		"@SUM(A1)":      "'@SUM(A1)",
+		"\t=1+1":        "'\t=1+1",
+		"\r=1+1":        "'\r=1+1",
+		"Jane=Guest":    "Jane=Guest",
+	} {
+		rec := innsecure.Booking{Name: name}.CSVRecord()
+		if len(rec) != len(innsecure.CSVColumns) {
+			t.Fatalf("want %d fields, got %q", len(innsecure.CSVColumns), rec)
+		}
+		if got := rec[5]; got != want {
+			t.Errorf("%q: want=%q, got=%q", name, want, got)
+		}
+	}
+}
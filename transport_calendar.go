@@ -0,0 +1,121 @@
+package innsecure
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// icsDate is the format of an RFC 5545 DATE value.
+const icsDate = "20060102"
+
+// decodeFeedRequest reads the hotel from the path and the feed token from the
+// token query parameter.
+func decodeFeedRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	hotelID, err := strconv.Atoi(mux.Vars(r)["org_id"])
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return FeedRequest{HotelID: hotelID, Token: r.URL.Query().Get("token")}, nil
+}
+
+// encodeCalendarResponse writes a Listing as an RFC 5545 calendar with an
+// all-day event for each stay, from arrival up to, but not including,
+// departure. Each event's UID is derived from its booking's ID and its
+// SEQUENCE from its version, so calendar clients update events in place as
+// bookings change.
+func encodeCalendarResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	l := response.(*Listing)
+	now := time.Now().UTC().Format("20060102T150405Z")
+
+	var c icsWriter
+	c.line("BEGIN:VCALENDAR")
+	c.line("VERSION:2.0")
+	c.line("PRODID:-//form3tech//innsecure//EN")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:PUBLISH")
+	c.line("X-WR-CALNAME:" + icsText("Hotel bookings"))
+	c.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	c.line("X-PUBLISHED-TTL:PT1H")
+	for _, b := range l.Data {
+		arrive, err := time.Parse(dateLayout, b.Arrive)
+		if err != nil {
+			continue
+		}
+		leave, err := time.Parse(dateLayout, b.Leave)
+		if err != nil || !leave.After(arrive) {
+			leave = arrive.AddDate(0, 0, 1)
+		}
+		status := "CONFIRMED"
+		if b.Status == BookingCancelled {
+			status = "CANCELLED"
+		}
+
+		c.line("BEGIN:VEVENT")
+		c.line("UID:" + icsText(b.ID+"@innsecure"))
+		c.line("DTSTAMP:" + now)
+		c.line("DTSTART;VALUE=DATE:" + arrive.Format(icsDate))
+		c.line("DTEND;VALUE=DATE:" + leave.Format(icsDate))
+		c.line("SEQUENCE:" + strconv.Itoa(b.Version))
+		c.line("STATUS:" + status)
+		c.line("SUMMARY:" + icsText(b.Name))
+		c.line("TRANSP:TRANSPARENT")
+		c.line("END:VEVENT")
+	}
+	c.line("END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="bookings.ics"`)
+	// The feed token is part of the URL, so must not leak to other sites.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	_, err := w.Write(c.buf.Bytes())
+	return err
+}
+
+// icsWriter writes the content lines of an RFC 5545 calendar.
+type icsWriter struct {
+	buf bytes.Buffer
+}
+
+// line writes a content line, folding it so that no line is longer than 75
+// octets, without splitting a UTF-8 character.
+func (c *icsWriter) line(s string) {
+	n := 0
+	for len(s) > 0 {
+		_, size := utf8.DecodeRuneInString(s)
+		if n+size > 75 {
+			c.buf.WriteString("\r\n ")
+			n = 1
+		}
+		c.buf.WriteString(s[:size])
+		n += size
+		s = s[size:]
+	}
+	c.buf.WriteString("\r\n")
+}
+
+// icsText escapes s as an RFC 5545 TEXT value. Control characters other than
+// newlines cannot be represented, so are dropped.
+func icsText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == ';' || r == ',':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r < 0x20 || r == 0x7f:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
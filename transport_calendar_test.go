@@ -0,0 +1,111 @@
+package innsecure_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	service := svc{
+		feedBookings: func(_ context.Context, hotelID int, token string) (*innsecure.Listing, error) {
+			if hotelID != 123 || token != "secret" {
+				return nil, innsecure.ErrUnauthorized
+			}
+			return &innsecure.Listing{Data: []innsecure.Booking{
+				{ID: "a", Version: 2, Arrive: "2021-08-13", Leave: "2021-08-15", Name: "Guest; with, \\specials", Status: innsecure.BookingCancelled},
+				{ID: "b", Arrive: "2021-08-13", Leave: "2021-08-13", Name: strings.Repeat("é", 60), Status: innsecure.BookingConfirmed},
+			}}, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hotels/123/bookings.ics?token=wrong", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("want=%d for wrong token, got=%d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hotels/123/bookings.ics?token=secret", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("want=%d, got=%d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Fatalf("want calendar, got %q", ct)
+	}
+
+	body := w.Body.Bytes()
+	for _, line := range bytes.Split(bytes.TrimSuffix(body, []byte("\r\n")), []byte("\r\n")) {
+		if len(line) > 75 {
+			t.Fatalf("want lines of at most 75 octets, got %q", line)
+		}
+		if bytes.ContainsRune(line, '\n') {
+			t.Fatalf("want CRLF line endings, got %q", line)
+		}
+	}
+	// Unfold, as a calendar client would.
+	ics := strings.Replace(string(body), "\r\n ", "", -1)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"UID:a@innsecure\r\n",
+		"DTSTART;VALUE=DATE:20210813\r\nDTEND;VALUE=DATE:20210815\r\nSEQUENCE:2\r\nSTATUS:CANCELLED\r\n",
+		"SUMMARY:Guest\\; with\\, \\\\specials\r\n",
+		// A departure on the day of arrival still lasts a day.
+		"DTSTART;VALUE=DATE:20210813\r\nDTEND;VALUE=DATE:20210814\r\nSEQUENCE:0\r\nSTATUS:CONFIRMED\r\n",
+		"SUMMARY:" + strings.Repeat("é", 60) + "\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Fatalf("want calendar containing %q, got %q", want, ics)
+		}
+	}
+}
+
+func TestFeedTokens(t *testing.T) {
+	var stored []byte
+	r := repo{
+		insertFeedToken: func(_ context.Context, ft innsecure.FeedToken, hash []byte) error {
+			stored = hash
+			return nil
+		},
+		feedTokenValid: func(ctx context.Context, hotelID int, hash []byte) (bool, error) {
+			if u := innsecure.UserFromContext(ctx); u == nil || u.HotelID != hotelID {
+				t.Fatalf("want the feed's hotel in the context, got %+v", u)
+			}
+			return bytes.Equal(hash, stored), nil
+		},
+		list: func(_ context.Context, hotelID int) ([]innsecure.Booking, error) {
+			return []innsecure.Booking{{ID: "a", HotelID: hotelID}}, nil
+		},
+	}
+	sut := innsecure.NewBookingService(r)
+
+	if _, err := sut.CreateFeedToken(context.TODO(), normalUser()); err != innsecure.ErrUnauthorized {
+		t.Fatalf("want=%s, got=%v", innsecure.ErrUnauthorized, err)
+	}
+	ft, err := sut.CreateFeedToken(context.TODO(), adminUser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ft.Token == "" || bytes.Contains(stored, []byte(ft.Token)) {
+		t.Fatalf("want a secret which is stored hashed, got %q", ft.Token)
+	}
+
+	if _, err := sut.FeedBookings(context.TODO(), 123, "wrong"); err != innsecure.ErrUnauthorized {
+		t.Fatalf("want=%s, got=%v", innsecure.ErrUnauthorized, err)
+	}
+	l, err := sut.FeedBookings(context.TODO(), 123, ft.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Data) != 1 {
+		t.Fatalf("want bookings, got %+v", l)
+	}
+}
@@ -0,0 +1,120 @@
+package innsecure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// heartbeatInterval is how often a comment is sent on an idle event stream,
+// so that proxies do not close it and clients notice if it is lost.
+const heartbeatInterval = 15 * time.Second
+
+// errStreamClosed is returned to an EventStream when its response has ended.
+var errStreamClosed = errors.New("event stream closed")
+
+// decodeWatchRequest reads the ID of the last event the client has seen from
+// the Last-Event-ID header, sent by EventSource clients when they reconnect.
+// Without it, only events from now on are sent.
+func decodeWatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		return int64(-1), nil
+	}
+	after, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || after < 0 {
+		return nil, withFields(ErrBadRequest, FieldError{Field: "Last-Event-ID", Message: "must be an event ID"})
+	}
+	return after, nil
+}
+
+type shutdownKey struct{}
+
+// ContextWithShutdown returns ctx, carrying a channel which is closed once the
+// server starts shutting down. Responses streamed for as long as the client
+// wants, such as event streams, end when it is, rather than hold up the
+// shutdown; clients reconnect, to another instance, and resume. It is meant
+// for an http.Server's BaseContext, with the channel closed by a function
+// registered with RegisterOnShutdown.
+func ContextWithShutdown(ctx context.Context, shutdown <-chan struct{}) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, shutdown)
+}
+
+// shuttingDown returns the channel closed when the server handling the
+// request of ctx starts shutting down, or nil, which is never ready, if
+// there is none.
+func shuttingDown(ctx context.Context) <-chan struct{} {
+	c, _ := ctx.Value(shutdownKey{}).(<-chan struct{})
+	return c
+}
+
+// encodeEventStream writes an EventStream as server-sent events, as they
+// happen, with a heartbeat comment whenever the stream is idle. It returns
+// when the stream ends, which it does when the client goes away, or the
+// server shuts down.
+func encodeEventStream(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	stream := response.(EventStream)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming not supported")
+	}
+	disableWriteTimeout(ctx)
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	var (
+		events = make(chan BookingEvent)
+		ended  = make(chan error, 1)
+		done   = make(chan struct{})
+	)
+	defer close(done)
+	go func() {
+		ended <- stream(func(e BookingEvent) error {
+			select {
+			case events <- e:
+				return nil
+			case <-done:
+				return errStreamClosed
+			}
+		})
+	}()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return nil
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return nil
+			}
+			heartbeat.Reset(heartbeatInterval)
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case <-ended:
+			// The response has started, so the error, if any, cannot be
+			// reported; the client reconnects from its last event.
+			return nil
+		case <-shuttingDown(ctx):
+			// The client reconnects from its last event, to another
+			// instance.
+			return nil
+		}
+		flusher.Flush()
+	}
+}
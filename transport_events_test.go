@@ -0,0 +1,151 @@
+package innsecure_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+func TestWatchBookingsStreamsEvents(t *testing.T) {
+	all := []innsecure.BookingEvent{
+		{ID: 1, Type: innsecure.EventCreated, BookingID: "a"},
+		{ID: 2, Type: innsecure.EventCreated, BookingID: "b"},
+		{ID: 3, Type: innsecure.EventCancelled, BookingID: "a", Version: 1},
+	}
+	r := repo{
+		events: func(_ context.Context, hotelID int, after int64, limit int) ([]innsecure.BookingEvent, error) {
+			if hotelID != 123 {
+				t.Errorf("want events of the user's hotel, got %d", hotelID)
+			}
+			var events []innsecure.BookingEvent
+			for _, e := range all {
+				if e.ID > after && len(events) < limit {
+					events = append(events, e)
+				}
+			}
+			return events, nil
+		},
+		lastEventID: func(context.Context, int) (int64, error) {
+			return 1, nil
+		},
+	}
+	s := innsecure.NewBookingService(r)
+	s.SetPollInterval(time.Millisecond)
+	srv := httptest.NewServer(innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(s, asAdmin), log.NewNopLogger()))
+	defer srv.Close()
+
+	// watch reads n events from the stream, resuming after lastEventID.
+	watch := func(lastEventID string, n int) []string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/hotels/123/bookings/stream", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("want=%d, got=%d", http.StatusOK, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Fatalf("want an event stream, got %q", ct)
+		}
+
+		var frames []string
+		s := bufio.NewScanner(resp.Body)
+		var frame []string
+		for len(frames) < n && s.Scan() {
+			if s.Text() != "" {
+				frame = append(frame, s.Text())
+				continue
+			}
+			if len(frame) > 0 && strings.HasPrefix(frame[0], "id: ") {
+				frames = append(frames, strings.Join(frame, "\n"))
+			}
+			frame = nil
+		}
+		if len(frames) < n {
+			t.Fatalf("want %d events, got %q (%v)", n, frames, s.Err())
+		}
+		return frames
+	}
+
+	got := watch("", 2)
+	if !strings.HasPrefix(got[0], "id: 2\nevent: created\ndata: ") || !strings.HasPrefix(got[1], "id: 3\nevent: cancelled\ndata: ") {
+		t.Fatalf("want events after the latest, got %q", got)
+	}
+	var e innsecure.BookingEvent
+	if err := json.Unmarshal([]byte(strings.SplitN(got[1], "data: ", 2)[1]), &e); err != nil || e != all[2] {
+		t.Fatalf("want=%+v, got=%+v (%v)", all[2], e, err)
+	}
+
+	got = watch("0", 3)
+	if !strings.HasPrefix(got[0], "id: 1\n") || !strings.HasPrefix(got[2], "id: 3\n") {
+		t.Fatalf("want every event after Last-Event-ID, got %q", got)
+	}
+}
+
+func TestWatchBookingsRejectsBadLastEventID(t *testing.T) {
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(innsecure.NewBookingService(repo{}), asAdmin), log.NewNopLogger())
+	req := httptest.NewRequest("GET", "/hotels/123/bookings/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("want=%d, got=%d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestEventStreamsEndOnShutdown(t *testing.T) {
+	r := repo{
+		events: func(context.Context, int, int64, int) ([]innsecure.BookingEvent, error) {
+			return nil, nil
+		},
+		lastEventID: func(context.Context, int) (int64, error) {
+			return 0, nil
+		},
+	}
+	s := innsecure.NewBookingService(r)
+	s.SetPollInterval(time.Millisecond)
+	shutdown := make(chan struct{})
+	srv := httptest.NewUnstartedServer(innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(s, asAdmin), log.NewNopLogger()))
+	srv.Config.BaseContext = func(net.Listener) context.Context {
+		return innsecure.ContextWithShutdown(context.Background(), shutdown)
+	}
+	srv.Config.RegisterOnShutdown(func() { close(shutdown) })
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/hotels/123/bookings/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	if !sc.Scan() {
+		t.Fatalf("want the stream started, got %v", sc.Err())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("want the server drained, got %v", err)
+	}
+	for sc.Scan() {
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatalf("want the stream ended, got %v", err)
+	}
+}
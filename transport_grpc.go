@@ -0,0 +1,169 @@
+package innsecure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/form3tech/innsecure/pb"
+)
+
+type grpcServer struct {
+	pb.UnimplementedBookingsServer
+	listBookings   grpctransport.Handler
+	createBooking  grpctransport.Handler
+	getBookingByID grpctransport.Handler
+}
+
+// MakeGRPCServer makes the service endpoints available as a gRPC
+// BookingsServer. The bearer token is read from the "authorization" metadata,
+// and the idempotency key for CreateBooking from "idempotency-key".
+func MakeGRPCServer(e Endpoints, logger log.Logger) pb.BookingsServer {
+	options := []grpctransport.ServerOption{
+		grpctransport.ServerErrorLogger(logger),
+		grpctransport.ServerBefore(jwt.GRPCToContext()),
+	}
+	return &grpcServer{
+		listBookings: grpctransport.NewServer(
+			e.ListBookings,
+			decodeGRPCListBookingsRequest,
+			encodeGRPCListBookingsResponse,
+			options...,
+		),
+		createBooking: grpctransport.NewServer(
+			e.CreateBooking,
+			decodeGRPCCreateBookingRequest,
+			encodeGRPCBooking,
+			append(options, grpctransport.ServerBefore(grpcIdempotencyKeyToContext))...,
+		),
+		getBookingByID: grpctransport.NewServer(
+			e.GetBookingByID,
+			decodeGRPCGetBookingRequest,
+			encodeGRPCBooking,
+			options...,
+		),
+	}
+}
+
+// GRPCRecovery returns a gRPC interceptor which reports a panic in a call as
+// an Internal error, logging it, rather than letting it end the process. It
+// does for gRPC what net/http does for each HTTP request.
+func GRPCRecovery(logger log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				logger.Log("method", info.FullMethod, "panic", p, "stack", string(debug.Stack()))
+				resp, err = nil, status.Error(codes.Internal, "Internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (s *grpcServer) ListBookings(ctx context.Context, req *pb.ListBookingsRequest) (*pb.ListBookingsResponse, error) {
+	_, resp, err := s.listBookings.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.ListBookingsResponse), nil
+}
+
+func (s *grpcServer) CreateBooking(ctx context.Context, req *pb.CreateBookingRequest) (*pb.Booking, error) {
+	_, resp, err := s.createBooking.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.Booking), nil
+}
+
+func (s *grpcServer) GetBooking(ctx context.Context, req *pb.GetBookingRequest) (*pb.Booking, error) {
+	_, resp, err := s.getBookingByID.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return resp.(*pb.Booking), nil
+}
+
+func decodeGRPCListBookingsRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeGRPCCreateBookingRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CreateBookingRequest)
+	if req.Booking == nil {
+		return nil, ErrBadRequest
+	}
+	return bookingFromPB(req.Booking), nil
+}
+
+func decodeGRPCGetBookingRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	return grpcReq.(*pb.GetBookingRequest).Id, nil
+}
+
+func encodeGRPCListBookingsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	l := response.(*Listing)
+	resp := &pb.ListBookingsResponse{Data: make([]*pb.Booking, 0, len(l.Data))}
+	for _, b := range l.Data {
+		resp.Data = append(resp.Data, bookingToPB(b))
+	}
+	return resp, nil
+}
+
+func encodeGRPCBooking(_ context.Context, response interface{}) (interface{}, error) {
+	return bookingToPB(*response.(*Booking)), nil
+}
+
+func bookingFromPB(b *pb.Booking) Booking {
+	return Booking{
+		Type:    b.Type,
+		ID:      b.Id,
+		Version: int(b.Version),
+		HotelID: int(b.HotelId),
+		Arrive:  b.Arrive,
+		Leave:   b.Leave,
+		Name:    b.Name,
+		Status:  b.Status,
+	}
+}
+
+func bookingToPB(b Booking) *pb.Booking {
+	return &pb.Booking{
+		Type:    b.Type,
+		Id:      b.ID,
+		Version: int32(b.Version),
+		HotelId: int32(b.HotelID),
+		Arrive:  b.Arrive,
+		Leave:   b.Leave,
+		Name:    b.Name,
+		Status:  b.Status,
+	}
+}
+
+// grpcError converts an error to a gRPC status, using the HTTP status AsError
+// gives it. Unexpected errors are reported without detail so as not to leak
+// internals.
+func grpcError(err error) error {
+	e := AsError(err)
+	switch e.Status {
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, e.Error())
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, e.Error())
+	case http.StatusUnprocessableEntity, http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, e.Error())
+	case http.StatusUnauthorized:
+		if errors.Is(err, ErrUnauthorized) {
+			return status.Error(codes.PermissionDenied, e.Error())
+		}
+		return status.Error(codes.Unauthenticated, e.Error())
+	}
+	return status.Error(codes.Internal, "Internal error")
+}
@@ -0,0 +1,167 @@
+package innsecure_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/form3tech/innsecure"
+	"github.com/form3tech/innsecure/jwtauth"
+	"github.com/form3tech/innsecure/pb"
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCServerReturnsBooking(t *testing.T) {
+	service := svc{
+		getBookingByID: func(_ context.Context, _ *innsecure.User, ID string) (*innsecure.Booking, error) {
+			b := validBooking(ID)
+			return &b, nil
+		},
+	}
+	sut := innsecure.MakeGRPCServer(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+
+	got, err := sut.GetBooking(context.TODO(), &pb.GetBookingRequest{Id: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != "A" || got.HotelId != 123 || got.Name != "Jane Guest" {
+		t.Fatalf("unexpected booking: %+v", got)
+	}
+}
+
+func TestGRPCServerMapsErrorsToStatusCodes(t *testing.T) {
+	cases := map[error]codes.Code{
+		innsecure.ErrNotFound:       codes.NotFound,
+		innsecure.ErrInvalidBooking: codes.InvalidArgument,
+		innsecure.ErrUnauthorized:   codes.PermissionDenied,
+		innsecure.ErrDatabase:       codes.Internal,
+	}
+	for in, want := range cases {
+		t.Run(in.Error(), func(t *testing.T) {
+			service := svc{
+				getBookingByID: func(_ context.Context, _ *innsecure.User, _ string) (*innsecure.Booking, error) {
+					return nil, in
+				},
+			}
+			sut := innsecure.MakeGRPCServer(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+
+			_, err := sut.GetBooking(context.TODO(), &pb.GetBookingRequest{Id: "A"})
+			if got := status.Code(err); got != want {
+				t.Fatalf("want=%s, got=%s", want, got)
+			}
+		})
+	}
+}
+
+// serveGRPC serves e over gRPC as the service does, and returns a client of
+// the server.
+func serveGRPC(t *testing.T, e innsecure.Endpoints) pb.BookingsClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer(grpc.ChainUnaryInterceptor(innsecure.GRPCRecovery(log.NewNopLogger())))
+	pb.RegisterBookingsServer(g, innsecure.MakeGRPCServer(e, log.NewNopLogger()))
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewBookingsClient(conn)
+}
+
+// withToken returns ctx, sending a bearer token signed with key carrying
+// claims.
+func withToken(t *testing.T, ctx context.Context, key string, claims stdjwt.MapClaims) context.Context {
+	token, err := stdjwt.NewWithClaims(stdjwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func TestGRPCRejectsTokensWithoutUserClaims(t *testing.T) {
+	service := svc{
+		getBookingByID: func(_ context.Context, u *innsecure.User, ID string) (*innsecure.Booking, error) {
+			b := validBooking(ID)
+			b.HotelID = u.HotelID
+			return &b, nil
+		},
+	}
+	client := serveGRPC(t, innsecure.MakeServerEndpoints(service, jwtauth.NewMiddleware("SigningString")))
+
+	for _, claims := range []stdjwt.MapClaims{
+		{},
+		{"name": "H.A. Kerr", "admin": true},
+		{"name": "H.A. Kerr", "admin": true, "hotel": "123"},
+		{"name": 1, "admin": true, "hotel": 123},
+		{"name": "H.A. Kerr", "hotel": 123},
+	} {
+		ctx := withToken(t, context.Background(), "SigningString", claims)
+		if _, err := client.GetBooking(ctx, &pb.GetBookingRequest{Id: "A"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%v: want=%s, got=%v", claims, codes.Unauthenticated, err)
+		}
+	}
+
+	ctx := withToken(t, context.Background(), "SigningString", stdjwt.MapClaims{"name": "H.A. Kerr", "admin": false, "hotel": 123})
+	got, err := client.GetBooking(ctx, &pb.GetBookingRequest{Id: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.HotelId != 123 {
+		t.Fatalf("want the token's hotel, got %d", got.HotelId)
+	}
+}
+
+func TestGRPCRejectsUnverifiedTokens(t *testing.T) {
+	service := svc{
+		getBookingByID: func(_ context.Context, _ *innsecure.User, ID string) (*innsecure.Booking, error) {
+			b := validBooking(ID)
+			return &b, nil
+		},
+	}
+	client := serveGRPC(t, innsecure.MakeServerEndpoints(service, jwtauth.NewMiddleware("SigningString")))
+	claims := stdjwt.MapClaims{"name": "H.A. Kerr", "admin": true, "hotel": 123}
+
+	ctx := withToken(t, context.Background(), "AnotherString", claims)
+	if _, err := client.GetBooking(ctx, &pb.GetBookingRequest{Id: "A"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("wrong key: want=%s, got=%v", codes.Unauthenticated, err)
+	}
+
+	unsigned, err := stdjwt.NewWithClaims(stdjwt.SigningMethodNone, claims).SignedString(stdjwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+unsigned)
+	if _, err := client.GetBooking(ctx, &pb.GetBookingRequest{Id: "A"}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("alg none: want=%s, got=%v", codes.Unauthenticated, err)
+	}
+}
+
+func TestGRPCRecoversFromPanics(t *testing.T) {
+	service := svc{
+		getBookingByID: func(_ context.Context, _ *innsecure.User, ID string) (*innsecure.Booking, error) {
+			if ID == "panic" {
+				panic("boom")
+			}
+			b := validBooking(ID)
+			return &b, nil
+		},
+	}
+	client := serveGRPC(t, innsecure.MakeServerEndpoints(service, noopMiddleware))
+
+	if _, err := client.GetBooking(context.Background(), &pb.GetBookingRequest{Id: "panic"}); status.Code(err) != codes.Internal {
+		t.Fatalf("want=%s, got=%v", codes.Internal, err)
+	}
+	if _, err := client.GetBooking(context.Background(), &pb.GetBookingRequest{Id: "A"}); err != nil {
+		t.Fatalf("want the server still serving, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package innsecure
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// maxJSONBytes limits the size of a JSON request body.
+const maxJSONBytes = 64 << 10
+
+var (
+	// ErrUnsupportedMediaType is returned if a request body is not of a
+	// media type accepted by the route.
+	ErrUnsupportedMediaType = errors.New("Unsupported media type")
+	// ErrRequestTooLarge is returned if a request body is larger than the
+	// route accepts.
+	ErrRequestTooLarge = errors.New("Request body too large")
+)
+
+// decodeJSON decodes the JSON body of r into v. Every decoder of a JSON
+// request body should use it, rather than decoding the body itself, so that
+// all of them are equally strict.
+func decodeJSON(r *http.Request, v interface{}) error {
+	body, err := readJSON(r)
+	if err != nil {
+		return err
+	}
+	return unmarshalJSON(body, v)
+}
+
+// readJSON reads the body of r, which must be declared as JSON and be at most
+// maxJSONBytes long.
+func readJSON(r *http.Request) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return nil, withFields(ErrUnsupportedMediaType, FieldError{Field: "Content-Type", Message: "must be application/json"})
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return nil, withFields(ErrUnsupportedMediaType, FieldError{Field: "Content-Type", Message: "must have charset utf-8"})
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxJSONBytes))
+	if err != nil {
+		if isBodyTooLarge(err) {
+			return nil, withFields(ErrRequestTooLarge, FieldError{Field: "body", Message: fmt.Sprintf("must be at most %dKB", maxJSONBytes>>10)})
+		}
+		return nil, ErrBadRequest
+	}
+	return body, nil
+}
+
+// isBodyTooLarge reports whether err is from reading past the limit of an
+// http.MaxBytesReader, which returns an error of no particular type.
+func isBodyTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "request body too large")
+}
+
+// unmarshalJSON decodes data, which must hold a single JSON value, into v.
+// Objects may not have fields which v does not. Errors name the field at
+// fault where there is one.
+func unmarshalJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return jsonError(err)
+	}
+	if len(bytes.TrimSpace(data[dec.InputOffset():])) > 0 {
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: "must hold a single JSON value"})
+	}
+	return nil
+}
+
+// jsonError converts an error decoding a JSON body to one which can be
+// reported to the client.
+func jsonError(err error) error {
+	var (
+		se *json.SyntaxError
+		te *json.UnmarshalTypeError
+	)
+	switch {
+	case err == io.EOF:
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: "is required"})
+	case err == io.ErrUnexpectedEOF:
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: "ends unexpectedly"})
+	case errors.As(err, &se):
+		return withFields(ErrBadRequest, FieldError{Field: "body", Message: fmt.Sprintf("is not valid JSON at byte %d", se.Offset)})
+	case errors.As(err, &te):
+		field := te.Field
+		if field == "" {
+			field = "body"
+		}
+		return withFields(ErrBadRequest, FieldError{Field: field, Message: "must be " + jsonType(te.Type)})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no type for this error, so the field is taken
+		// from its message.
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return withFields(ErrBadRequest, FieldError{Field: field, Message: "is not allowed"})
+	}
+	return ErrBadRequest
+}
+
+// jsonType describes the JSON values which decode into t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a " + t.String()
+}
@@ -0,0 +1,83 @@
+package innsecure_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+// jsonRequest returns a request with a JSON body.
+func jsonRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestStrictJSONDecoding(t *testing.T) {
+	service := svc{
+		createBooking: func(_ context.Context, b innsecure.Booking) (*innsecure.Booking, error) {
+			return &b, nil
+		},
+		eraseGuest: func(context.Context, *innsecure.User, innsecure.ErasureRequest) (*innsecure.Erasure, error) {
+			return &innsecure.Erasure{}, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+
+	valid := `{"type": "Booking", "hotel_id": 123, "arrive": "2021-08-13", "leave": "2021-08-15", "name": "John Guest"}`
+	cases := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+		wantField   string
+	}{
+		{name: "valid", path: "/hotels/123/bookings", contentType: "application/json; charset=utf-8", body: valid, wantStatus: http.StatusCreated},
+		{name: "no content type", path: "/hotels/123/bookings", body: valid, wantStatus: http.StatusUnsupportedMediaType, wantCode: "unsupported_media_type", wantField: "Content-Type"},
+		{name: "form", path: "/hotels/123/bookings", contentType: "application/x-www-form-urlencoded", body: valid, wantStatus: http.StatusUnsupportedMediaType, wantCode: "unsupported_media_type", wantField: "Content-Type"},
+		{name: "other charset", path: "/hotels/123/bookings", contentType: "application/json; charset=latin1", body: valid, wantStatus: http.StatusUnsupportedMediaType, wantCode: "unsupported_media_type", wantField: "Content-Type"},
+		{name: "too large", path: "/hotels/123/bookings", contentType: "application/json", body: `{"name": "` + strings.Repeat("a", 100<<10) + `"}`, wantStatus: http.StatusRequestEntityTooLarge, wantCode: "request_too_large", wantField: "body"},
+		{name: "empty", path: "/hotels/123/bookings", contentType: "application/json", wantStatus: http.StatusBadRequest, wantCode: "malformed_request", wantField: "body"},
+		{name: "syntax error", path: "/hotels/123/bookings", contentType: "application/json", body: `{"type": }`, wantStatus: http.StatusBadRequest, wantCode: "malformed_request", wantField: "body"},
+		{name: "truncated", path: "/hotels/123/bookings", contentType: "application/json", body: `{"type": "Booking"`, wantStatus: http.StatusBadRequest, wantCode: "malformed_request", wantField: "body"},
+		{name: "trailing data", path: "/hotels/123/bookings", contentType: "application/json", body: valid + ` {}`, wantStatus: http.StatusBadRequest, wantCode: "malformed_request", wantField: "body"},
+		{name: "control characters", path: "/hotels/123/bookings", contentType: "application/json", body: strings.Replace(valid, "John Guest", `John\u0000Guest`, 1), wantStatus: http.StatusBadRequest, wantCode: "invalid_booking", wantField: "name"},
+		{name: "unknown field", path: "/hotels/123/erasures", contentType: "application/json", body: `{"name": "John Guest", "all": true}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_erasure", wantField: "all"},
+		{name: "wrong type", path: "/hotels/123/erasures", contentType: "application/json", body: `{"name": 1}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_erasure", wantField: "name"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", c.path, strings.NewReader(c.body))
+			if c.contentType != "" {
+				r.Header.Set("Content-Type", c.contentType)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != c.wantStatus {
+				t.Fatalf("want=%d, got=%d: %s", c.wantStatus, w.Code, w.Body.String())
+			}
+			if c.wantCode == "" {
+				return
+			}
+			var got struct {
+				Code   string                 `json:"code"`
+				Fields []innsecure.FieldError `json:"fields"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Code != c.wantCode || len(got.Fields) != 1 || got.Fields[0].Field != c.wantField {
+				t.Fatalf("want %s for %s, got %+v", c.wantCode, c.wantField, got)
+			}
+		})
+	}
+}
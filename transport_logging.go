@@ -0,0 +1,182 @@
+package innsecure
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+
+	"github.com/form3tech/innsecure/trace"
+)
+
+// RequestIDHeader is the request and response header identifying a request.
+// Clients may send one, so that the request can be traced across services;
+// otherwise the service generates one.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of a request ID sent by a client.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+type accessLogKey struct{}
+
+// accessLogEntry collects what is known about a request as it is handled.
+type accessLogEntry struct {
+	route string
+	org   string
+	user  *User
+}
+
+// RequestIDFromContext returns the ID of the request being handled, or ""
+// outside of a request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithUser returns ctx, authenticated as u. Authentication middleware
+// should use it, rather than setting UserContextKey itself, so that the user
+// appears in the access log.
+func ContextWithUser(ctx context.Context, u *User) context.Context {
+	if e, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		e.user = u
+	}
+	return context.WithValue(ctx, UserContextKey, u)
+}
+
+// AccessLog returns middleware which identifies each request with a request
+// ID, and logs it once it has been handled.
+//
+// Log entries hold the route matched rather than the path requested, and
+// neither headers, query parameters nor bodies, so that they never contain
+// credentials such as bearer and feed tokens, or guests' personal data.
+func AccessLog(logger log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.New()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			entry := &accessLogEntry{route: "unmatched"}
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			ctx = context.WithValue(ctx, accessLogKey{}, entry)
+			lw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+			// Logged even if the handler aborts the response by panicking.
+			defer func() {
+				keyvals := []interface{}{
+					"request_id", id,
+					"trace_id", traceID(ctx),
+					"method", r.Method,
+					"route", entry.route,
+					"status", lw.status,
+					"bytes", lw.bytes,
+					"duration", time.Since(begin),
+				}
+				if entry.user != nil {
+					keyvals = append(keyvals, "user", entry.user.Name, "hotel_id", entry.user.HotelID)
+				} else if entry.org != "" {
+					keyvals = append(keyvals, "hotel_id", entry.org)
+				}
+				logger.Log(keyvals...)
+			}()
+			next.ServeHTTP(lw, r.WithContext(ctx))
+		})
+	}
+}
+
+// noteRoute is router middleware recording the route matched for the access
+// log, and naming the request's span after it.
+func noteRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				span := trace.FromContext(r.Context())
+				span.SetName(r.Method + " " + tpl)
+				span.SetAttribute("http.route", tpl)
+			}
+		}
+		if e, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					e.route = tpl
+				}
+			}
+			if org, err := strconv.Atoi(mux.Vars(r)["org_id"]); err == nil {
+				e.org = strconv.Itoa(org)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logError is a go-kit transport.ErrorHandler which logs errors with the ID
+// of the request they occurred in.
+func logError(logger log.Logger) func(ctx context.Context, err error) {
+	return func(ctx context.Context, err error) {
+		logger.Log("request_id", RequestIDFromContext(ctx), "trace_id", traceID(ctx), "err", err)
+	}
+}
+
+// traceID returns the ID of the trace of the request of ctx, or "" if it is
+// not traced.
+func traceID(ctx context.Context) string {
+	if sc := trace.FromContext(ctx).Context(); sc.IsValid() {
+		return sc.TraceID.String()
+	}
+	return ""
+}
+
+// validRequestID reports whether a request ID sent by a client is safe to
+// log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// accessLogWriter records the status and size of a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush satisfies http.Flusher, so that responses can still be streamed.
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,105 @@
+package innsecure_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+// logLines records log lines as maps.
+type logLines []map[string]string
+
+func (l *logLines) Log(keyvals ...interface{}) error {
+	line := map[string]string{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		line[fmt.Sprint(keyvals[i])] = fmt.Sprint(keyvals[i+1])
+	}
+	*l = append(*l, line)
+	return nil
+}
+
+func TestAccessLog(t *testing.T) {
+	service := svc{
+		createBooking: func(_ context.Context, b innsecure.Booking) (*innsecure.Booking, error) {
+			return &b, nil
+		},
+		getBookingByID: func(context.Context, *innsecure.User, string) (*innsecure.Booking, error) {
+			return nil, innsecure.ErrNotFound
+		},
+	}
+	var access, errs logLines
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, asAdmin), &errs)
+	h = innsecure.AccessLog(&access)(h)
+
+	r := httptest.NewRequest("GET", "/v1/hotels/123/bookings/a?token=feed-secret", nil)
+	r.Header.Set("Authorization", "Bearer jwt-secret")
+	r.Header.Set(innsecure.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get(innsecure.RequestIDHeader); got != "req-1" {
+		t.Fatalf("want the request ID echoed, got %q", got)
+	}
+	var problem struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil || problem.RequestID != "req-1" {
+		t.Fatalf("want the request ID in the error, got %+v (%v)", problem, err)
+	}
+	if len(errs) != 1 || errs[0]["request_id"] != "req-1" {
+		t.Fatalf("want the error logged with the request ID, got %v", errs)
+	}
+	if len(access) != 1 {
+		t.Fatalf("want one access log entry, got %v", access)
+	}
+	want := map[string]string{
+		"request_id": "req-1",
+		"method":     "GET",
+		"route":      "/v1/hotels/{org_id}/bookings/{id}",
+		"status":     "404",
+		"user":       "admin",
+		"hotel_id":   "123",
+	}
+	for k, v := range want {
+		if access[0][k] != v {
+			t.Errorf("%s: want=%q, got=%q", k, v, access[0][k])
+		}
+	}
+
+	body := `{"type": "Booking", "hotel_id": 123, "arrive": "2021-08-13", "leave": "2021-08-15", "name": "John Guest"}`
+	r = jsonRequest("POST", "/hotels/123/bookings", body)
+	r.Header.Set(innsecure.RequestIDHeader, "bad\nid")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if id := w.Header().Get(innsecure.RequestIDHeader); id == "" || strings.Contains(id, "bad") {
+		t.Fatalf("want an invalid request ID replaced, got %q", id)
+	}
+
+	for _, line := range append(access, errs...) {
+		for _, v := range line {
+			for _, secret := range []string{"jwt-secret", "feed-secret", "John Guest"} {
+				if strings.Contains(v, secret) {
+					t.Fatalf("want no secrets or guest names logged, got %v", line)
+				}
+			}
+		}
+	}
+}
+
+func TestAccessLogKeepsStreaming(t *testing.T) {
+	var flushed bool
+	h := innsecure.AccessLog(log.NewNopLogger())(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, flushed = w.(http.Flusher)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !flushed {
+		t.Fatal("want the response writer to remain a Flusher")
+	}
+}
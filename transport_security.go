@@ -0,0 +1,124 @@
+package innsecure
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/form3tech/innsecure/trace"
+)
+
+// corsMaxAge is how long browsers may cache the response to a preflight
+// request.
+const corsMaxAge = 10 * time.Minute
+
+var (
+	// corsAllowedHeaders are the request headers which browser apps may send.
+	corsAllowedHeaders = []string{"Authorization", "Content-Type", IdempotencyKeyHeader, "If-Match", "If-None-Match", "Last-Event-ID", RequestIDHeader, trace.TraceparentHeader, trace.TracestateHeader}
+	// corsExposedHeaders are the response headers which browser apps may
+	// read, besides those which browsers always expose.
+	corsExposedHeaders = []string{"ETag", "Deprecation", "Sunset", "Link", "Content-Disposition", RequestIDHeader}
+)
+
+// CORS returns middleware which lets browser apps served from the given
+// origins, such as "https://frontdesk.example.com", call the API. An origin
+// of "*" allows any. Preflight requests are answered by the middleware
+// itself, and refused if their origin is not allowed.
+//
+// Clients authenticate with the Authorization header rather than cookies, so
+// credentialed requests are not allowed.
+func CORS(origins []string) func(http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		if o = strings.TrimSpace(o); o != "" {
+			allowed[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+		}
+	}
+	allows := func(origin string) bool {
+		return origin != "" && (allowed["*"] || allowed[strings.ToLower(origin)])
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				if !allows(origin) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allows(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SecurityHeaders returns middleware which adds headers hardening responses
+// against misuse by browsers. Strict-Transport-Security is sent with the
+// given max age, unless it is zero.
+//
+// Responses hold guests' personal data unless they say otherwise, so they
+// are marked not to be stored by caches. Handlers of responses which may be
+// cached set their own Cache-Control.
+func SecurityHeaders(hsts time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			if hsts > 0 {
+				h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hsts.Seconds()))+"; includeSubDomains")
+			}
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+			h.Set("Cache-Control", "no-store")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type connContextKey struct{}
+
+// ConnContext is an http.Server ConnContext function which lets streamed
+// responses lift or extend the server's WriteTimeout: event streams, which
+// last for as long as the client wants, and exports, which last for as long
+// as the client keeps reading.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// disableWriteTimeout lifts the server's WriteTimeout for the rest of the
+// response to the request of ctx. The server sets it again for the next
+// request on the connection.
+func disableWriteTimeout(ctx context.Context) {
+	if c, ok := ctx.Value(connContextKey{}).(net.Conn); ok {
+		c.SetWriteDeadline(time.Time{})
+	}
+}
+
+// extendWriteDeadline gives the response to the request of ctx the server's
+// WriteTimeout from now to write what follows, so that a long response is
+// not cut off part way, but a client which stops reading it is.
+func extendWriteDeadline(ctx context.Context) {
+	c, ok := ctx.Value(connContextKey{}).(net.Conn)
+	srv, _ := ctx.Value(http.ServerContextKey).(*http.Server)
+	if ok && srv != nil && srv.WriteTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(srv.WriteTimeout))
+	}
+}
@@ -0,0 +1,206 @@
+package innsecure_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+func TestCORS(t *testing.T) {
+	service := svc{
+		listBookings: func(context.Context, *innsecure.User) (*innsecure.Listing, error) {
+			return &innsecure.Listing{}, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+	h = innsecure.CORS([]string{"https://frontdesk.example.com/"})(h)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("OPTIONS", "/hotels/123/bookings", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := preflight("https://frontdesk.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("want=%d, got=%d", http.StatusNoContent, w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://frontdesk.example.com" {
+		t.Fatalf("want the origin allowed, got %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") || !strings.Contains(got, "Content-Type") || !strings.Contains(got, innsecure.RequestIDHeader) {
+		t.Fatalf("want the request headers allowed, got %q", got)
+	}
+
+	w = preflight("https://evil.example.com")
+	if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("want preflight from another origin refused, got=%d %v", w.Code, w.Header())
+	}
+
+	r := httptest.NewRequest("GET", "/hotels/123/bookings", nil)
+	r.Header.Set("Origin", "https://frontdesk.example.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://frontdesk.example.com" {
+		t.Fatalf("want request from allowed origin served with CORS headers, got=%d %v", w.Code, w.Header())
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(got, "ETag") {
+		t.Fatalf("want ETag exposed, got %q", got)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	service := svc{
+		listBookings: func(context.Context, *innsecure.User) (*innsecure.Listing, error) {
+			return &innsecure.Listing{}, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger())
+	h = innsecure.SecurityHeaders(24 * time.Hour)(h)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hotels/123/bookings", nil))
+	for header, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=86400; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"Cache-Control":             "no-store",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: want=%q, got=%q", header, want, got)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	if got := w.Header().Get("Cache-Control"); got == "no-store" {
+		t.Fatalf("want the spec cacheable, got %q", got)
+	}
+}
+
+func TestEventStreamOutlivesWriteTimeout(t *testing.T) {
+	start := time.Now()
+	r := repo{
+		events: func(_ context.Context, _ int, after int64, _ int) ([]innsecure.BookingEvent, error) {
+			if after > 0 || time.Since(start) < 300*time.Millisecond {
+				return nil, nil
+			}
+			return []innsecure.BookingEvent{{ID: 1, Type: innsecure.EventCreated, BookingID: "a"}}, nil
+		},
+		lastEventID: func(context.Context, int) (int64, error) {
+			return 0, nil
+		},
+	}
+	s := innsecure.NewBookingService(r)
+	s.SetPollInterval(time.Millisecond)
+	srv := httptest.NewUnstartedServer(innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(s, asAdmin), log.NewNopLogger()))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Config.ConnContext = innsecure.ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/hotels/123/bookings/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if sc.Text() == "id: 1" {
+			return
+		}
+	}
+	t.Fatalf("want an event after the write timeout, got %v", sc.Err())
+}
+
+func TestExportOutlivesWriteTimeout(t *testing.T) {
+	r := repo{
+		export: func(_ context.Context, hotelID int, fn func(innsecure.Booking) error) error {
+			for i := 0; i < 5; i++ {
+				time.Sleep(50 * time.Millisecond)
+				if err := fn(validBooking(strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	srv := httptest.NewUnstartedServer(innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(innsecure.NewBookingService(r), asAdmin), log.NewNopLogger()))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Config.ConnContext = innsecure.ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/hotels/123/bookings:export", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("want the whole export, got %v", err)
+	}
+	if n := strings.Count(string(body), "\n"); n != 5 {
+		t.Fatalf("want 5 bookings, got %d", n)
+	}
+}
+
+func TestExportTimesOutStalledClient(t *testing.T) {
+	ended := make(chan error, 1)
+	r := repo{
+		export: func(_ context.Context, hotelID int, fn func(innsecure.Booking) error) error {
+			b := validBooking("a")
+			b.Name = strings.Repeat("x", 64<<10)
+			for i := 0; i < 10000; i++ {
+				if err := fn(b); err != nil {
+					ended <- err
+					return err
+				}
+			}
+			ended <- nil
+			return nil
+		},
+	}
+	srv := httptest.NewUnstartedServer(innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(innsecure.NewBookingService(r), asAdmin), log.NewNopLogger()))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Config.ConnContext = innsecure.ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	// The client sends the request, then never reads the response.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /hotels/123/bookings:export HTTP/1.1\r\nHost: innsecure\r\nAccept: application/x-ndjson\r\n\r\n")
+
+	select {
+	case err := <-ended:
+		if err == nil {
+			t.Fatal("want the export to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the export to give up on the client")
+	}
+}
@@ -0,0 +1,106 @@
+package innsecure
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// APIVersion is a version of the HTTP API. Every version serves every route,
+// under paths prefixed with its name, from the same endpoints. A version
+// whose JSON differs from the current shapes maps its requests and responses
+// to and from them, so that the service only deals in the current shapes.
+type APIVersion struct {
+	// Name is the version's path prefix, such as "v1".
+	Name string
+	// MapRequest, if set, rewrites a request to this version into the
+	// current shape before it is decoded. route names the route, as in
+	// "POST /hotels/{org_id}/bookings".
+	MapRequest func(route string, r *http.Request) error
+	// MapResponse, if set, rewrites a response into this version's shape
+	// before it is encoded.
+	MapResponse func(route string, response interface{}) interface{}
+}
+
+// V1 is the first version of the API, whose shapes are the current ones. Its
+// routes are also served without a prefix, as they were before the API was
+// versioned.
+var V1 = APIVersion{Name: "v1"}
+
+// Deprecation signals to clients of a route that it is deprecated, with the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) response headers.
+type Deprecation struct {
+	// At is when the route was, or will be, deprecated.
+	At time.Time `json:"deprecated_at"`
+	// Sunset, if set, is when the route is expected to stop being served.
+	Sunset time.Time `json:"sunset"`
+	// Link, if set, is the URL of documentation about the deprecation.
+	Link string `json:"link,omitempty"`
+}
+
+// HandlerOption configures MakeHTTPHandler.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	versions     []APIVersion
+	deprecations map[string]Deprecation
+}
+
+// WithVersions serves the given versions of the API as well as V1.
+func WithVersions(versions ...APIVersion) HandlerOption {
+	return func(o *handlerOptions) {
+		o.versions = append(o.versions, versions...)
+	}
+}
+
+// WithDeprecations marks routes as deprecated. Routes are named by method
+// and full path, as in "GET /v1/hotels/{org_id}/bookings", or
+// "GET /hotels/{org_id}/bookings" for the unversioned alias of that route.
+func WithDeprecations(deprecations map[string]Deprecation) HandlerOption {
+	return func(o *handlerOptions) {
+		for route, d := range deprecations {
+			o.deprecations[route] = d
+		}
+	}
+}
+
+// decoder returns dec, preceded by the version's request mapping.
+func (v APIVersion) decoder(route string, dec httptransport.DecodeRequestFunc) httptransport.DecodeRequestFunc {
+	if v.MapRequest == nil {
+		return dec
+	}
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		if err := v.MapRequest(route, r); err != nil {
+			return nil, err
+		}
+		return dec(ctx, r)
+	}
+}
+
+// encoder returns enc, preceded by the version's response mapping.
+func (v APIVersion) encoder(route string, enc httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	if v.MapResponse == nil {
+		return enc
+	}
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		return enc(ctx, w, v.MapResponse(route, response))
+	}
+}
+
+// handler returns next, with the deprecation's headers added to its
+// responses.
+func (d Deprecation) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.At.Unix(), 10))
+		if !d.Sunset.IsZero() {
+			w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Link != "" {
+			w.Header().Add("Link", "<"+d.Link+`>; rel="deprecation"; type="text/html"`)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
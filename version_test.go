@@ -0,0 +1,119 @@
+package innsecure_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/form3tech/innsecure"
+	"github.com/go-kit/kit/log"
+)
+
+// v2 is an example version which calls guests "guest" rather than "name".
+var v2 = innsecure.APIVersion{
+	Name: "v2",
+	MapRequest: func(route string, r *http.Request) error {
+		if route != "POST /hotels/{org_id}/bookings" {
+			return nil
+		}
+		var v map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			return innsecure.ErrBadRequest
+		}
+		v["name"] = v["guest"]
+		delete(v, "guest")
+		body, _ := json.Marshal(v)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil
+	},
+	MapResponse: func(_ string, response interface{}) interface{} {
+		b, ok := response.(*innsecure.Booking)
+		if !ok {
+			return response
+		}
+		return map[string]interface{}{"id": b.ID, "guest": b.Name}
+	},
+}
+
+func TestVersionsShareEndpoints(t *testing.T) {
+	var created innsecure.Booking
+	service := svc{
+		getBookingByID: func(_ context.Context, _ *innsecure.User, ID string) (*innsecure.Booking, error) {
+			return &innsecure.Booking{ID: ID, Name: "Jane Guest"}, nil
+		},
+		createBooking: func(_ context.Context, b innsecure.Booking) (*innsecure.Booking, error) {
+			created = b
+			return &b, nil
+		},
+	}
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger(), innsecure.WithVersions(v2))
+
+	get := func(path string) map[string]interface{} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: want=%d, got=%d", path, http.StatusOK, w.Code)
+		}
+		var v map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, path := range []string{"/hotels/123/bookings/a", "/v1/hotels/123/bookings/a"} {
+		if got := get(path); got["name"] != "Jane Guest" || got["guest"] != nil {
+			t.Fatalf("%s: want v1 shape, got %v", path, got)
+		}
+	}
+	if got := get("/v2/hotels/123/bookings/a"); got["guest"] != "Jane Guest" || got["name"] != nil {
+		t.Fatalf("want v2 shape, got %v", got)
+	}
+
+	body := `{"type": "Booking", "hotel_id": 123, "arrive": "2021-08-13", "leave": "2021-08-15", "guest": "John Guest"}`
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, jsonRequest("POST", "/v2/hotels/123/bookings", body))
+	if w.Code != http.StatusCreated || created.Name != "John Guest" {
+		t.Fatalf("want v2 request mapped, got=%d with %+v: %s", w.Code, created, w.Body.String())
+	}
+}
+
+func TestDeprecatedRoutesSendHeaders(t *testing.T) {
+	service := svc{
+		listBookings: func(context.Context, *innsecure.User) (*innsecure.Listing, error) {
+			return &innsecure.Listing{}, nil
+		},
+	}
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := innsecure.MakeHTTPHandler(innsecure.MakeServerEndpoints(service, noopMiddleware), log.NewNopLogger(),
+		innsecure.WithDeprecations(map[string]innsecure.Deprecation{
+			"GET /hotels/{org_id}/bookings": {
+				At:     time.Unix(1700000000, 0),
+				Sunset: sunset,
+				Link:   "https://example.com/migrating-to-v1",
+			},
+		}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hotels/123/bookings", nil))
+	if got := w.Header().Get("Deprecation"); got != "@1700000000" {
+		t.Fatalf("want Deprecation header, got %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Tue, 01 Jan 2030 00:00:00 GMT" {
+		t.Fatalf("want Sunset header, got %q", got)
+	}
+	if got := w.Header().Get("Link"); !strings.Contains(got, `<https://example.com/migrating-to-v1>; rel="deprecation"`) {
+		t.Fatalf("want deprecation Link header, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/hotels/123/bookings", nil))
+	if got := w.Header().Get("Deprecation"); got != "" {
+		t.Fatalf("want no Deprecation header for the v1 route, got %q", got)
+	}
+}
@@ -0,0 +1,234 @@
+package innsecure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidWebhook is returned if a webhook subscription is invalid.
+const ErrInvalidWebhook ErrorString = "Invalid webhook"
+
+// ErrInvalidSignature is returned by VerifyWebhookSignature if a delivery was
+// not signed with the webhook's secret, or was signed too long ago.
+const ErrInvalidSignature ErrorString = "Invalid webhook signature"
+
+// Headers sent with every webhook delivery.
+const (
+	// WebhookIDHeader identifies the delivery. It is the same for every
+	// attempt, so receivers can use it to ignore deliveries they have
+	// already processed.
+	WebhookIDHeader = "Innsecure-Webhook-Id"
+	// WebhookSignatureHeader holds the time the delivery was signed and its
+	// signature, as "t=<unix seconds>,v1=<hex HMAC-SHA256>".
+	WebhookSignatureHeader = "Innsecure-Webhook-Signature"
+)
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead marks a delivery which failed too many times to be tried
+	// again.
+	DeliveryDead = "dead"
+)
+
+// webhookSecretPrefix marks webhook secrets, so that they are easily
+// recognised, for instance by secret scanners.
+const webhookSecretPrefix = "whsec_"
+
+// Webhook subscribes a URL to changes to a hotel's bookings.
+type Webhook struct {
+	ID      string `json:"id"`
+	HotelID int    `json:"hotel_id"`
+	URL     string `json:"url"`
+	// Events are the types of event delivered, as in BookingEvent.
+	Events []string `json:"events"`
+	// Secret is the key with which deliveries are signed. It is only
+	// returned when the webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookListing contains a list of webhooks.
+type WebhookListing struct {
+	Data []Webhook `json:"data"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, and the outcome
+// of its latest attempt.
+type WebhookDelivery struct {
+	ID             int64        `json:"id"`
+	HotelID        int          `json:"hotel_id"`
+	WebhookID      string       `json:"webhook_id"`
+	Event          BookingEvent `json:"event"`
+	Status         string       `json:"status"`
+	Attempts       int          `json:"attempts"`
+	LastAttemptAt  *time.Time   `json:"last_attempt_at,omitempty"`
+	LastStatusCode int          `json:"last_status_code,omitempty"`
+	LastError      string       `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time   `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time   `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// WebhookDeliveryListing contains a list of webhook deliveries.
+type WebhookDeliveryListing struct {
+	Data []WebhookDelivery `json:"data"`
+}
+
+// webhookPayload is the body of a webhook delivery.
+type webhookPayload struct {
+	ID      int64        `json:"id"`
+	HotelID int          `json:"hotel_id"`
+	Event   BookingEvent `json:"event"`
+}
+
+// newWebhookSecret returns a new random webhook secret.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// SignWebhook returns the signature header of a delivery of body at the
+// given time. The timestamp is signed with the body, so a delivery cannot be
+// replayed later with a new one.
+func SignWebhook(secret string, body []byte, at time.Time) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + t + ",v1=" + webhookMAC(secret, t, body)
+}
+
+// VerifyWebhookSignature checks the signature header of a delivery of body,
+// as a receiver should, rejecting deliveries signed more than tolerance from
+// now. Receivers should also ignore deliveries whose WebhookIDHeader they
+// have already seen.
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration) error {
+	var t string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			t = kv[1]
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	want := webhookMAC(secret, t, body)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(sig), []byte(want)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// webhookMAC returns the hex HMAC-SHA256 of the timestamp and body.
+func webhookMAC(secret, t string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookErrors returns the problems with a webhook subscription, if any.
+// Unless allowPrivate is set, its URL must use HTTPS and every address its
+// host resolves to must be public, so that webhooks cannot be used to reach
+// services on the internal network.
+func webhookErrors(ctx context.Context, w Webhook, allowPrivate bool) []FieldError {
+	var errs []FieldError
+	if len(w.Events) == 0 {
+		errs = append(errs, FieldError{Field: "events", Message: "must not be empty"})
+	}
+	for i, e := range w.Events {
+		if e != EventCreated && e != EventUpdated && e != EventCancelled {
+			errs = append(errs, FieldError{Field: "events[" + strconv.Itoa(i) + "]", Message: "must be an event type"})
+		}
+	}
+	if msg := webhookURLError(ctx, w.URL, allowPrivate); msg != "" {
+		errs = append(errs, FieldError{Field: "url", Message: msg})
+	}
+	return errs
+}
+
+// webhookURLError describes what is wrong with a webhook URL, if anything.
+func webhookURLError(ctx context.Context, raw string, allowPrivate bool) string {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Hostname() == "" {
+		return "must be an absolute URL"
+	}
+	if u.User != nil {
+		return "must not contain credentials"
+	}
+	if u.Scheme != "https" && !(allowPrivate && u.Scheme == "http") {
+		return "must use https"
+	}
+	if allowPrivate {
+		return ""
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return "must have a host which resolves"
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return "must not be an internal address"
+		}
+	}
+	return ""
+}
+
+// privateNetworks are the networks which webhooks may not be delivered to:
+// loopback, private, link-local, shared, reserved and multicast addresses.
+var privateNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+		"::/127", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP reports whether ip is outside all of the privateNetworks.
+func publicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,247 @@
+package innsecure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/form3tech/innsecure/trace"
+)
+
+const (
+	// webhookTimeout limits each attempt at a delivery.
+	webhookTimeout = 10 * time.Second
+	// webhookLease is how long a claimed delivery is held by a dispatcher
+	// before another may try it, in case the first has died.
+	webhookLease = time.Minute
+	// webhookBatchSize limits the number of deliveries claimed at once.
+	webhookBatchSize = 50
+	// webhookConcurrency limits the number of deliveries attempted at once.
+	webhookConcurrency = 8
+	// webhookMaxAttempts is the number of attempts after which a delivery
+	// is dead. With webhookBackoff, the last is about four and a quarter hours
+	// after the first.
+	webhookMaxAttempts = 10
+)
+
+// errPrivateAddress is returned when a webhook's host resolves to an
+// internal address at the time of delivery.
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// DueWebhookDelivery is a delivery which is due to be attempted, with the
+// webhook it is for.
+type DueWebhookDelivery struct {
+	Delivery WebhookDelivery
+	URL      string
+	Secret   string
+}
+
+// WebhookRepository stores the deliveries of webhooks.
+type WebhookRepository interface {
+	// ClaimWebhookDeliveries returns up to limit pending deliveries which
+	// are due, across all hotels, and holds them for lease so that they are
+	// not claimed again while they are attempted.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]DueWebhookDelivery, error)
+	// UpdateWebhookDelivery records the outcome of an attempt at a delivery.
+	UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error
+}
+
+// WebhookDispatcher delivers booking events to the webhooks subscribed to
+// them, retrying failed deliveries with exponential backoff.
+type WebhookDispatcher struct {
+	r        WebhookRepository
+	client   *http.Client
+	interval time.Duration
+	logger   log.Logger
+	tracer   *trace.Tracer
+}
+
+// NewWebhookDispatcher returns a dispatcher which checks for due deliveries
+// every interval. Unless allowPrivate is set, deliveries are never made to
+// internal addresses, whatever the webhook's host resolves to at the time.
+func NewWebhookDispatcher(r WebhookRepository, interval time.Duration, allowPrivate bool, logger log.Logger) *WebhookDispatcher {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		// Checking the address actually dialled, rather than the URL,
+		// stops DNS from being changed to point at an internal address
+		// after the webhook is created.
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &WebhookDispatcher{
+		r: r,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   webhookTimeout,
+			// A redirect could lead anywhere, so is reported as a
+			// failure rather than followed.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// SetTracer traces each attempt at a delivery with t. Webhooks receive the
+// trace context of the attempt in the traceparent header.
+func (d *WebhookDispatcher) SetTracer(t *trace.Tracer) {
+	d.tracer = t
+}
+
+// Run delivers due deliveries immediately, then every interval until the
+// context is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		if d.RunOnce(ctx) == webhookBatchSize && ctx.Err() == nil {
+			// More deliveries may already be due.
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce attempts one batch of due deliveries and returns its size.
+func (d *WebhookDispatcher) RunOnce(ctx context.Context) int {
+	due, err := d.r.ClaimWebhookDeliveries(ctx, webhookBatchSize, webhookLease)
+	if err != nil {
+		d.logger.Log("job", "webhooks", "err", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, webhookConcurrency)
+	for _, dd := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dd DueWebhookDelivery) {
+			defer func() { <-sem; wg.Done() }()
+			d.deliver(ctx, dd)
+		}(dd)
+	}
+	wg.Wait()
+	return len(due)
+}
+
+// deliver attempts a delivery and records the outcome.
+func (d *WebhookDispatcher) deliver(ctx context.Context, dd DueWebhookDelivery) {
+	del := dd.Delivery
+	ctx, span := d.tracer.Start(ctx, "webhook.deliver", trace.SpanContext{})
+	span.SetAttribute("webhook_id", del.WebhookID)
+	span.SetAttribute("delivery_id", fmt.Sprint(del.ID))
+	now := time.Now().UTC()
+	code, err := d.send(ctx, dd, now)
+	span.SetAttribute("http.status_code", strconv.Itoa(code))
+	span.End(err)
+	if ctx.Err() != nil {
+		// Stopped rather than failed: the delivery is tried again when
+		// its lease expires.
+		return
+	}
+
+	del.Attempts++
+	del.LastAttemptAt = &now
+	del.LastStatusCode = code
+	del.LastError = ""
+	del.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		del.Status = DeliveryDelivered
+		del.DeliveredAt = &now
+	case del.Attempts >= webhookMaxAttempts:
+		del.Status = DeliveryDead
+		del.LastError = err.Error()
+	default:
+		next := now.Add(webhookBackoff(del.Attempts))
+		del.Status = DeliveryPending
+		del.LastError = err.Error()
+		del.NextAttemptAt = &next
+	}
+
+	// The repository limits what is visible to the authenticated user's
+	// hotel, which for a delivery is the hotel of its webhook.
+	ctx = ContextWithUser(ctx, &User{Name: "webhooks", HotelID: del.HotelID})
+	if err := d.r.UpdateWebhookDelivery(ctx, del); err != nil {
+		d.logger.Log("job", "webhooks", "delivery", del.ID, "err", err)
+		return
+	}
+	d.logger.Log("job", "webhooks", "delivery", del.ID, "webhook", del.WebhookID, "status", del.Status, "attempts", del.Attempts)
+}
+
+// send posts a delivery to its webhook, signed at the given time, and
+// returns the status code of the response, if there was one. Only 2xx
+// responses are successful.
+func (d *WebhookDispatcher) send(ctx context.Context, dd DueWebhookDelivery, at time.Time) (int, error) {
+	body, err := json.Marshal(webhookPayload{
+		ID:      dd.Delivery.ID,
+		HotelID: dd.Delivery.HotelID,
+		Event:   dd.Delivery.Event,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", dd.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "innsecure-webhooks/1.0")
+	req.Header.Set(WebhookIDHeader, fmt.Sprint(dd.Delivery.ID))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(dd.Secret, body, at))
+	trace.Inject(ctx, req.Header)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookBackoff returns how long to wait before trying a delivery again
+// after the given number of attempts: 30 seconds, doubling with each attempt,
+// give or take a tenth so that failures at once are not retried at once.
+func webhookBackoff(attempts int) time.Duration {
+	d := 30 * time.Second << uint(attempts-1)
+	if max := 6 * time.Hour; d > max || d <= 0 {
+		d = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(d)/5)) - d/10
+	return d + jitter
+}